@@ -1,8 +1,7 @@
 package main
 
 import (
-	"log"
-	"net/http"
+	"fmt"
 	"strconv"
 	"strings"
 
@@ -15,16 +14,13 @@ import (
 type Response struct {
 	Metadata   repos.Metadata     `json:"metadata"`
 	Audiobooks []*repos.Audiobook `json:"audiobooks"`
+	Facets     *repos.Facets      `json:"facets,omitempty"`
 }
 type GenresResponse struct {
 	Metadata repos.Metadata    `json:"metadata"`
 	Genres   []*repos.GenreDTO `json:"genres"`
 }
 
-type ApiError struct {
-	Error map[string]error `json:"error"`
-}
-
 func (app *app) listHandler() func(c echo.Context) error {
 	return func(c echo.Context) error {
 
@@ -37,7 +33,7 @@ func (app *app) listHandler() func(c echo.Context) error {
 		if c.QueryParam("lengthMin") != "" {
 			totalTimeMin, err = strconv.Atoi(c.QueryParam("lengthMin"))
 			if err != nil {
-				return c.JSON(http.StatusBadRequest, Error.NewError().Set("length", "Must be an integer"))
+				return Error.NewError().Set("length", "Must be an integer")
 			}
 		} else {
 			totalTimeMin = 0
@@ -45,7 +41,7 @@ func (app *app) listHandler() func(c echo.Context) error {
 		if c.QueryParam("lengthMax") != "" {
 			totalTimeMax, err = strconv.Atoi(c.QueryParam("lengthMax"))
 			if err != nil {
-				return c.JSON(http.StatusBadRequest, Error.NewError().Set("length", "Must be an integer"))
+				return Error.NewError().Set("length", "Must be an integer")
 			}
 		} else {
 			totalTimeMax = 0
@@ -67,10 +63,26 @@ func (app *app) listHandler() func(c echo.Context) error {
 			query.Genres = []string{}
 		}
 
+		switch c.QueryParam("facets") {
+		case "":
+		case "all":
+			query.Facets = []string{repos.GenresFacet, repos.LanguageFacet, repos.LengthFacet}
+		default:
+			query.Facets = strings.Split(c.QueryParam("facets"), ",")
+		}
+
+		if _, ok := c.QueryParams()["cursor"]; ok {
+			if c.QueryParam("page") != "" {
+				return Error.NewError().Set("cursor", "cursor and page cannot be used together")
+			}
+			query.UseCursor = true
+			query.Cursor = c.QueryParam("cursor")
+		}
+
 		if c.QueryParam("page") != "" {
 			query.Page, err = strconv.Atoi(c.QueryParam("page"))
 			if err != nil {
-				return c.JSON(http.StatusBadRequest, Error.NewError().Set("page", "Must be an integer"))
+				return Error.NewError().Set("page", "Must be an integer")
 			}
 		} else {
 			query.Page = 1
@@ -79,29 +91,30 @@ func (app *app) listHandler() func(c echo.Context) error {
 		if c.QueryParam("page_size") != "" {
 			query.PageSize, err = strconv.Atoi(c.QueryParam("page_size"))
 			if err != nil {
-				return c.JSON(http.StatusBadRequest, Error.NewError().Set("page_size", "Must be an integer"))
+				return Error.NewError().Set("page_size", "Must be an integer")
 			}
 		} else {
-			query.PageSize = 20
+			query.PageSize = app.cfg.DefaultPageSize
 		}
 
-		error := query.Validate()
-		if error != nil {
-			log.Print(error)
-			return c.JSON(400, error)
+		if err := query.Validate(app.limits()); err != nil {
+			return err
 		}
 
-		audiobooks, meta, err := app.services.AudiobooksService.List(query)
+		audiobooks, meta, err := app.services.AudiobooksService.List(c.Request().Context(), query)
 		if err != nil {
-			log.Print(err)
-			if len(audiobooks) == 0 {
-				return c.JSON(404, err)
-			}
-			return c.JSON(500, err)
+			return err
+		}
+
+		facets, err := app.services.AudiobooksService.Facets(c.Request().Context(), query)
+		if err != nil {
+			return err
 		}
+
 		return c.JSON(200, Response{
 			Metadata:   meta,
 			Audiobooks: audiobooks,
+			Facets:     facets,
 		})
 
 	}
@@ -115,9 +128,9 @@ func (app *app) GetHandler() func(c echo.Context) error {
 		// 	return c.JSON(400, err.Error())
 		// }
 
-		audiobook, err := app.services.AudiobooksService.Get(id)
+		audiobook, err := app.services.AudiobooksService.Get(c.Request().Context(), id)
 		if err != nil {
-			return c.JSON(400, err)
+			return err
 		}
 
 		return c.JSON(200, audiobook)
@@ -128,30 +141,14 @@ func (app *app) GetHandler() func(c echo.Context) error {
 func (app *app) ListGenresHandler() func(c echo.Context) error {
 	return func(c echo.Context) error {
 
-		var page, page_size int
-		var err error
-
-		if c.QueryParam("page_size") != "" {
-			page_size, err = strconv.Atoi(c.QueryParam("page_size"))
-			if err != nil {
-				return c.JSON(http.StatusBadRequest, Error.NewError().Set("page_size", "Must be an integer"))
-			}
-		} else {
-			page_size = 20
-		}
-
-		if c.QueryParam("page") != "" {
-			page, err = strconv.Atoi(c.QueryParam("page"))
-			if err != nil {
-				return c.JSON(http.StatusBadRequest, Error.NewError().Set("page", "Must be an integer"))
-			}
-		} else {
-			page = 1
+		page, page_size, paramErr := app.readPagination(c)
+		if paramErr != nil {
+			return paramErr
 		}
-		genres, meta, err := app.services.AudiobooksService.GetGenres(page, page_size)
 
+		genres, meta, err := app.services.AudiobooksService.GetGenres(c.Request().Context(), page, page_size)
 		if err != nil {
-			return c.JSON(500, "Error")
+			return err
 		}
 
 		return c.JSON(200, GenresResponse{
@@ -160,3 +157,63 @@ func (app *app) ListGenresHandler() func(c echo.Context) error {
 		})
 	}
 }
+
+func (app *app) SimilarHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+
+		id := c.Param("id")
+
+		page, page_size, paramErr := app.readPagination(c)
+		if paramErr != nil {
+			return paramErr
+		}
+
+		audiobooks, meta, err := app.services.AudiobooksService.GetSimilarBooks(c.Request().Context(), id, page, page_size)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(200, Response{
+			Metadata:   meta,
+			Audiobooks: audiobooks,
+		})
+	}
+}
+
+// limits are the bounds on a single query from the config
+func (app *app) limits() services.Limits {
+	return services.Limits{
+		MaxPageSize:     app.cfg.MaxPageSize,
+		MaxSearchLength: app.cfg.MaxSearchLength,
+		MaxGenres:       app.cfg.MaxGenres,
+	}
+}
+
+// readPagination parses and validates the page and page_size query params
+func (app *app) readPagination(c echo.Context) (int, int, *Error.Err) {
+	page, page_size := 1, app.cfg.DefaultPageSize
+	var err error
+
+	if c.QueryParam("page_size") != "" {
+		page_size, err = strconv.Atoi(c.QueryParam("page_size"))
+		if err != nil {
+			return 0, 0, Error.NewError().Set("page_size", "Must be an integer")
+		}
+	}
+
+	if c.QueryParam("page") != "" {
+		page, err = strconv.Atoi(c.QueryParam("page"))
+		if err != nil {
+			return 0, 0, Error.NewError().Set("page", "Must be an integer")
+		}
+	}
+
+	if page_size > app.cfg.MaxPageSize || page_size < 1 {
+		return 0, 0, Error.NewError().Set("page_size", fmt.Sprintf("max value is %d and min value is 1", app.cfg.MaxPageSize))
+	}
+	if page < 1 {
+		return 0, 0, Error.NewError().Set("page", "min value is 1")
+	}
+
+	return page, page_size, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/mayank12gt/free-audiobooks-backend/internal/repos"
+)
+
+type AuthorsResponse struct {
+	Metadata repos.Metadata     `json:"metadata"`
+	Authors  []*repos.AuthorDTO `json:"authors"`
+}
+
+type AuthorResponse struct {
+	Author     *repos.AuthorDTO   `json:"author"`
+	Metadata   repos.Metadata     `json:"metadata"`
+	Audiobooks []*repos.Audiobook `json:"audiobooks"`
+}
+
+func (app *app) ListAuthorsHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+
+		search := c.QueryParam("search")
+		if paramErr := app.limits().CheckSearch("search", search); paramErr != nil {
+			return paramErr
+		}
+
+		page, page_size, paramErr := app.readPagination(c)
+		if paramErr != nil {
+			return paramErr
+		}
+
+		authors, meta, err := app.services.AudiobooksService.ListAuthors(c.Request().Context(), search, page, page_size)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(200, AuthorsResponse{
+			Metadata: meta,
+			Authors:  authors,
+		})
+	}
+}
+
+func (app *app) GetAuthorHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+
+		id := c.Param("id")
+
+		page, page_size, paramErr := app.readPagination(c)
+		if paramErr != nil {
+			return paramErr
+		}
+
+		author, audiobooks, meta, err := app.services.AudiobooksService.GetAuthor(c.Request().Context(), id, page, page_size)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(200, AuthorResponse{
+			Author:     author,
+			Metadata:   meta,
+			Audiobooks: audiobooks,
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	Error "github.com/mayank12gt/free-audiobooks-backend/internal/errors"
+	"github.com/mayank12gt/free-audiobooks-backend/internal/logging"
+)
+
+// ErrorResponse is the body of every error answer. Code is stable for clients to switch on,
+// Fields holds one message per invalid request parameter
+type ErrorResponse struct {
+	Code      string            `json:"code"`
+	Message   string            `json:"message"`
+	Fields    map[string]string `json:"fields,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+}
+
+// httpErrorHandler turns every error a handler or middleware returns into an ErrorResponse
+func (app *app) httpErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	status := http.StatusInternalServerError
+	response := ErrorResponse{
+		Code:      string(Error.KindInternal),
+		Message:   "internal server error",
+		RequestID: logging.RequestID(c.Request().Context()),
+	}
+
+	var httpErr *echo.HTTPError
+	if e, ok := Error.As(err); ok {
+		status = e.Status()
+		response.Code = string(e.Kind())
+		response.Message = e.Message()
+		if len(e.E) != 0 {
+			response.Fields = e.E
+		}
+	} else if errors.As(err, &httpErr) {
+		// echo's own errors, such as an unknown route or a method that isn't allowed
+		status = httpErr.Code
+		response.Code = statusCode(status)
+		response.Message = strings.ToLower(http.StatusText(status))
+		if message, ok := httpErr.Message.(string); ok {
+			response.Message = strings.ToLower(message)
+		}
+	}
+
+	logger := logging.FromContext(c.Request().Context())
+	if status >= 500 {
+		logger.Error("request failed", "err", err)
+	}
+
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(status)
+	} else {
+		err = c.JSON(status, response)
+	}
+	if err != nil {
+		logger.Error("writing the error response failed", "err", err)
+	}
+}
+
+// statusCode names a status the way error kinds are named, 404 gives not_found
+func statusCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return string(Error.KindValidation)
+	case http.StatusNotFound:
+		return string(Error.KindNotFound)
+	}
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/mayank12gt/free-audiobooks-backend/internal/logging"
+	"github.com/mayank12gt/free-audiobooks-backend/internal/repos"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// readinessCheck is one dependency /readyz looks at. A check that isn't required is
+// reported but doesn't take the instance out of rotation when it fails
+type readinessCheck struct {
+	name     string
+	required bool
+	run      func(ctx context.Context) (interface{}, error)
+}
+
+// ReadyResponse only tells the status of every check, /readyz is public and the errors and
+// details of a check can describe the database. Those go to the log instead
+type ReadyResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+type CatalogAge struct {
+	LastUpdated *time.Time `json:"last_updated"`
+	AgeSeconds  int64      `json:"age_seconds,omitempty"`
+}
+
+// mongoChecks pings the database and compares its indexes with the spec, the index check
+// only fails readiness in index check mode fail and is left out in mode off
+func mongoChecks(db *mongo.Database, mode string) []readinessCheck {
+	checks := []readinessCheck{{
+		name:     "mongo",
+		required: true,
+		run: func(ctx context.Context) (interface{}, error) {
+			return nil, db.Client().Ping(ctx, readpref.Primary())
+		},
+	}}
+
+	if mode == "off" {
+		return checks
+	}
+	return append(checks, readinessCheck{
+		name:     "indexes",
+		required: mode == "fail",
+		run: func(ctx context.Context) (interface{}, error) {
+			problems, err := repos.VerifyIndexes(ctx, db)
+			if err != nil {
+				return nil, err
+			}
+			if len(problems) != 0 {
+				return problems, errors.New(strings.Join(problems, "; "))
+			}
+			return nil, nil
+		},
+	})
+}
+
+// catalogCheck reports how old the catalog is, it fails once the catalog is older than
+// the configured max age and is only required when one is set
+func (app *app) catalogCheck() readinessCheck {
+	maxAge := app.cfg.MaxCatalogAge
+	return readinessCheck{
+		name:     "catalog",
+		required: maxAge > 0,
+		run: func(ctx context.Context) (interface{}, error) {
+			meta, err := app.services.AudiobooksService.CatalogMeta(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if meta == nil {
+				return CatalogAge{}, errors.New("the catalog has never been seeded")
+			}
+			age := time.Since(meta.LastUpdated)
+			detail := CatalogAge{LastUpdated: &meta.LastUpdated, AgeSeconds: int64(age.Seconds())}
+			if maxAge > 0 && age > maxAge {
+				return detail, fmt.Errorf("the catalog is older than %s", maxAge)
+			}
+			return detail, nil
+		},
+	}
+}
+
+// HealthHandler answers liveness probes, it only tells that the process is serving requests
+func (app *app) HealthHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+		return c.JSON(200, map[string]string{"status": "ok"})
+	}
+}
+
+// ReadyHandler runs every readiness check and answers 503 when a required one fails
+func (app *app) ReadyHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+
+		logger := logging.FromContext(c.Request().Context())
+		response := ReadyResponse{Status: "ok", Checks: make(map[string]string)}
+		for _, check := range app.readiness {
+			ctx, cancel := context.WithTimeout(c.Request().Context(), app.cfg.ReadyTimeout)
+			start := time.Now()
+			detail, err := check.run(ctx)
+			cancel()
+
+			status := "ok"
+			if err != nil {
+				status = "failing"
+				if check.required {
+					response.Status = "unavailable"
+				}
+				logger.Warn("readiness check failing", "check", check.name, "required", check.required,
+					"duration_ms", time.Since(start).Milliseconds(), "err", err, "detail", detail)
+			} else {
+				logger.Debug("readiness check ok", "check", check.name,
+					"duration_ms", time.Since(start).Milliseconds(), "detail", detail)
+			}
+			response.Checks[check.name] = status
+		}
+
+		if response.Status != "ok" {
+			return c.JSON(http.StatusServiceUnavailable, response)
+		}
+		return c.JSON(200, response)
+	}
+}
@@ -2,99 +2,201 @@ package main
 
 import (
 	"context"
-	"log"
+	"fmt"
+	"log/slog"
 	"os"
-
-	"github.com/joho/godotenv"
+	"time"
 
 	"github.com/labstack/echo/v4"
-	"github.com/labstack/echo/v4/middleware"
 
+	"github.com/mayank12gt/free-audiobooks-backend/internal/config"
+	"github.com/mayank12gt/free-audiobooks-backend/internal/logging"
+	"github.com/mayank12gt/free-audiobooks-backend/internal/migrations"
+	"github.com/mayank12gt/free-audiobooks-backend/internal/repos"
 	"github.com/mayank12gt/free-audiobooks-backend/internal/services"
+	"github.com/mayank12gt/free-audiobooks-backend/internal/tracing"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
 type app struct {
-	logger   *log.Logger
-	services services.Services
+	cfg       *config.Config
+	logger    *slog.Logger
+	services  services.Services
+	readiness []readinessCheck
 }
 
 func main() {
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080" // Default port if $PORT is not set
+	cfg, err := config.Load(config.API, nil, os.Args[1:])
+	if err != nil {
+		logging.Fatal("can't load the configuration", "err", err)
 	}
+	logger := logging.New(os.Stdout, cfg.LogLevel, cfg.LogFormat)
 
-	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
-
-	if err := godotenv.Load(); err != nil {
-		log.Print("no env file found")
+	shutdownTracing, err := tracing.Setup(context.Background(), "free-audiobooks-api", cfg.TraceExporter, cfg.TraceEndpoint, cfg.TraceSampleRatio)
+	if err != nil {
+		logging.Fatal("can't set up tracing", "err", err)
 	}
 
-	dsn := os.Getenv("DSN")
-	if dsn == "" {
-		log.Print("No DSN found")
-	}
+	var repo repos.AudiobooksRepository
+	var readiness []readinessCheck
+	closeDB := func() {}
 
-	db, err := openDB(dsn)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer func() {
-		if err := db.Client().Disconnect(context.TODO()); err != nil {
-			log.Panic(err)
+	switch cfg.Store {
+	case "memory":
+		memoryRepo, err := repos.LoadMemoryRepo(cfg.MemoryData)
+		if err != nil {
+			logging.Fatal("can't load the memory store", "err", err)
 		}
-	}()
+		logger.Info("using in-memory store", "file", cfg.MemoryData)
+		repo = memoryRepo
+	default:
+		db, err := openDB(cfg)
+		if err != nil {
+			logging.Fatal("can't connect to MongoDB", "err", err)
+		}
+		closeDB = func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := db.Client().Disconnect(ctx); err != nil {
+				logger.Error("DB disconnect failed", "err", err)
+				return
+			}
+			logger.Info("DB disconnected")
+		}
+		checkSchema(db)
+		checkIndexes(db, cfg.IndexCheck)
+		mongoRepo := repos.NewAudiobookRepo(db)
+		mongoRepo.Timeouts = repos.Timeouts{Query: cfg.QueryTimeout, Search: cfg.SearchTimeout}
+		repo = mongoRepo
+		readiness = append(readiness, mongoChecks(db, cfg.IndexCheck)...)
+	}
 
 	app := &app{
-		logger:   logger,
-		services: services.NewService(db),
+		cfg:       cfg,
+		logger:    logger,
+		services:  services.NewService(repo, cfg.ViewFlushInterval),
+		readiness: readiness,
 	}
+	app.readiness = append(app.readiness, app.catalogCheck())
 
-	err = app.serve(port)
+	// serve only returns once in-flight requests are drained, so the database can go after it
+	err = app.serve()
+	app.flushViews()
+	closeDB()
+	flushTraces(shutdownTracing)
 	if err != nil {
-		app.logger.Fatal(err)
+		logging.Fatal("server failed", "err", err)
 	}
 
 }
 
-func (app *app) serve(port string) error {
-	server := echo.New()
-	//server.Use(middleware.CORS())
-	server.Use(middleware.CORSWithConfig(middleware.CORSConfig{
+func (app *app) registerHandlers(server *echo.Echo) {
+	server.GET("/audiobooks", app.listHandler())
 
-		AllowOrigins: []string{"*"},
-		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept},
-	}))
-	app.registerHandlers(server)
-	err := server.Start(":" + port)
-	app.logger.Printf("server started")
+	server.GET("/audiobooks/:id", app.GetHandler())
 
-	if err != nil {
-		return err
-	}
+	server.GET("/audiobooks/:id/similar", app.SimilarHandler())
 
-	return nil
+	server.GET("/genres", app.ListGenresHandler())
 
-}
+	server.GET("/authors", app.ListAuthorsHandler())
 
-func (app *app) registerHandlers(server *echo.Echo) {
-	server.GET("/audiobooks", app.listHandler())
+	server.GET("/authors/:id", app.GetAuthorHandler())
 
-	server.GET("/audiobooks/:id", app.GetHandler())
+	server.GET("/suggest", app.SuggestHandler())
 
-	server.GET("/genres", app.ListGenresHandler())
+	server.GET("/stats", app.StatsHandler())
+
+	server.GET("/healthz", app.HealthHandler())
+
+	server.GET("/readyz", app.ReadyHandler())
+
+	server.GET("/metrics", app.MetricsHandler())
 
 }
 
-func openDB(dsn string) (*mongo.Database, error) {
-	opts := options.Client().ApplyURI(dsn)
+// openDB connects and pings MongoDB, retrying with exponential backoff so the API can start
+// before the database is reachable
+func openDB(cfg *config.Config) (*mongo.Database, error) {
+	opts := options.Client().ApplyURI(cfg.DSN)
 	client, err := mongo.Connect(context.TODO(), opts)
 	if err != nil {
 		return nil, err
 	}
-	log.Print("DB connected")
-	return client.Database("audiobooksDB"), nil
+
+	attempts := cfg.DBConnectAttempts
+	delay := time.Second
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err = client.Ping(ctx, readpref.Primary())
+		cancel()
+		if err == nil {
+			break
+		}
+		if attempt == attempts {
+			client.Disconnect(context.TODO())
+			return nil, fmt.Errorf("can't reach MongoDB after %d attempts: %w", attempts, err)
+		}
+		slog.Warn("MongoDB ping failed", "err", err, "retry", attempt, "retries", attempts-1, "delay", delay)
+		time.Sleep(delay)
+		delay = min(delay*2, 30*time.Second)
+	}
+
+	slog.Info("DB connected", "db", cfg.Database)
+	return client.Database(cfg.Database), nil
+}
+
+// flushViews writes the detail views counted since the last batch, the database has to be open
+func (app *app) flushViews() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := app.services.Close(ctx); err != nil {
+		slog.Error("recording views failed", "err", err)
+	}
+}
+
+// flushTraces sends the spans still buffered before the process exits
+func flushTraces(shutdown func(context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdown(ctx); err != nil {
+		slog.Error("flushing traces failed", "err", err)
+	}
+}
+
+// checkSchema refuses to start on a database migrated past what this build understands
+func checkSchema(db *mongo.Database) {
+	version, err := migrations.Check(context.TODO(), db)
+	if err != nil {
+		logging.Fatal("schema check failed", "err", err)
+	}
+	if version < migrations.Latest() {
+		slog.Warn("database schema is behind, run migrate up", "version", version, "latest", migrations.Latest())
+	}
+}
+
+// checkIndexes compares the database indexes with the spec at startup. mode fail refuses
+// to start when an index is missing or differs, warn only logs it and off skips the check
+func checkIndexes(db *mongo.Database, mode string) {
+	if mode == "off" {
+		return
+	}
+
+	problems, err := repos.VerifyIndexes(context.TODO(), db)
+	if err != nil {
+		slog.Error("index check failed", "err", err)
+		return
+	}
+	for _, problem := range problems {
+		slog.Warn("index problem", "problem", problem)
+	}
+	if len(problems) != 0 {
+		if mode == "fail" {
+			logging.Fatal("indexes don't match the spec, run the seeder's indexes command")
+		}
+		slog.Warn("indexes don't match the spec, run the seeder's indexes command")
+	}
 }
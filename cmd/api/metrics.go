@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/mayank12gt/free-audiobooks-backend/internal/logging"
+	"github.com/mayank12gt/free-audiobooks-backend/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// recordMetrics counts every request and times it by route
+func recordMetrics() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			if err := next(c); err != nil {
+				c.Error(err)
+			}
+
+			route := routeLabel(c)
+			method := methodLabel(c.Request().Method)
+			status := strconv.Itoa(c.Response().Status)
+
+			metrics.HTTPRequests.WithLabelValues(route, method, status).Inc()
+			metrics.HTTPDuration.WithLabelValues(route, method, status).Observe(time.Since(start).Seconds())
+			return nil
+		}
+	}
+}
+
+// catalogCollector reports the catalog the seeder last wrote to meta_data, read at scrape time
+type catalogCollector struct {
+	app     *app
+	records *prometheus.Desc
+	updated *prometheus.Desc
+}
+
+func (app *app) newCatalogCollector() *catalogCollector {
+	return &catalogCollector{
+		app: app,
+		records: prometheus.NewDesc("audiobooks_catalog_records",
+			"Books in the catalog as of the last seeder run, complete and incomplete.", nil, nil),
+		updated: prometheus.NewDesc("audiobooks_catalog_last_updated_timestamp_seconds",
+			"When the seeder last finished a run.", nil, nil),
+	}
+}
+
+func (cc *catalogCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cc.records
+	ch <- cc.updated
+}
+
+func (cc *catalogCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), cc.app.cfg.ReadyTimeout)
+	defer cancel()
+
+	meta, err := cc.app.services.AudiobooksService.CatalogMeta(ctx)
+	if err != nil {
+		logging.FromContext(ctx).Warn("reading the catalog meta for metrics failed", "err", err)
+		return
+	}
+	if meta == nil {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(cc.records, prometheus.GaugeValue, float64(meta.TotalRecords))
+	ch <- prometheus.MustNewConstMetric(cc.updated, prometheus.GaugeValue, float64(meta.LastUpdated.Unix()))
+}
+
+// MetricsHandler serves the process wide HTTP and database metrics together with the catalog
+// collector of this app, which lives in a registry of its own so every app can register one
+func (app *app) MetricsHandler() func(c echo.Context) error {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(app.newCatalogCollector())
+	gatherers := prometheus.Gatherers{prometheus.DefaultGatherer, registry}
+	return echo.WrapHandler(promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{}))
+}
@@ -1 +1,226 @@
 package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	Error "github.com/mayank12gt/free-audiobooks-backend/internal/errors"
+	"github.com/mayank12gt/free-audiobooks-backend/internal/logging"
+	"github.com/mayank12gt/free-audiobooks-backend/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// filterParams are the query parameters the access log records, the rest of the query is left out
+var filterParams = []string{"search", "genres", "language", "lengthMin", "lengthMax", "sort_by", "page", "page_size", "facets", "cursor", "q", "limit"}
+
+// requestID gives every request an ID, the caller's X-Request-Id when it is usable. The ID is
+// echoed in the response and tags every line logged with the request's context
+func requestID() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			id := c.Request().Header.Get(echo.HeaderXRequestID)
+			if !validRequestID(id) {
+				id = newRequestID()
+			}
+			c.Response().Header().Set(echo.HeaderXRequestID, id)
+
+			ctx := logging.WithRequestID(c.Request().Context(), id)
+			c.SetRequest(c.Request().WithContext(ctx))
+			return next(c)
+		}
+	}
+}
+
+// validRequestID accepts short IDs of letters, digits, dashes and underscores so a client can't
+// put anything odd in the logs
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// routeLabel is the route a request matched, requests that match none share one label so
+// scanning for random paths can't grow metrics or span names
+func routeLabel(c echo.Context) string {
+	if c.Path() == "" {
+		return "unmatched"
+	}
+	return c.Path()
+}
+
+// methodLabel is the request method when it is a standard one and "other" otherwise, the method
+// is whatever the client sends so it can't go into labels or span names as it is
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodPatch, http.MethodHead, http.MethodOptions:
+		return method
+	}
+	return "other"
+}
+
+// traceRequests starts a span for every request, the service and repo spans hang below it.
+// Only the route and the normalised method go into the span, the query may hold search text.
+// Sampled requests log their trace ID
+func traceRequests() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			request := c.Request()
+			route := routeLabel(c)
+			method := methodLabel(request.Method)
+			ctx, span := tracing.StartServer(request.Context(), request.Header, method+" "+route,
+				attribute.String("http.request.method", method),
+				attribute.String("http.route", route),
+				attribute.String("request_id", logging.RequestID(request.Context())),
+			)
+			defer span.End()
+
+			if span.SpanContext().IsSampled() {
+				ctx = logging.WithLogger(ctx, logging.FromContext(ctx).With("trace_id", span.SpanContext().TraceID().String()))
+			}
+			c.SetRequest(request.WithContext(ctx))
+
+			if err := next(c); err != nil {
+				c.Error(err)
+			}
+
+			status := c.Response().Status
+			span.SetAttributes(attribute.Int("http.response.status_code", status))
+			if status >= 500 {
+				span.SetStatus(codes.Error, http.StatusText(status))
+			}
+			return nil
+		}
+	}
+}
+
+// accessLog logs one line per request once the answer is written. Errors are handed to the
+// error handler here rather than after the middleware so the line carries the final status
+func (app *app) accessLog() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			if err := next(c); err != nil {
+				c.Error(err)
+			}
+
+			request := c.Request()
+			status := c.Response().Status
+			attrs := []any{
+				"method", request.Method,
+				"route", c.Path(),
+				"path", request.URL.Path,
+				"status", status,
+				"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+				"bytes", c.Response().Size,
+				"ip", c.RealIP(),
+			}
+			query := request.URL.Query()
+			for _, param := range filterParams {
+				if value := query.Get(param); value != "" {
+					attrs = append(attrs, param, value)
+				}
+			}
+
+			level := slog.LevelInfo
+			if status >= 500 {
+				level = slog.LevelError
+			}
+			logging.FromContext(request.Context()).Log(request.Context(), level, "request", attrs...)
+			return nil
+		}
+	}
+}
+
+// unlimitedRoutes are polled by orchestrators and scrapers and never throttled
+var unlimitedRoutes = map[string]bool{"/healthz": true, "/readyz": true, "/metrics": true}
+
+// expensive reports whether a request draws on the search budget: text search, facets and
+// the aggregations behind similar books, the author list and stats
+func expensive(c echo.Context) bool {
+	switch c.Path() {
+	case "/audiobooks/:id/similar", "/authors", "/stats":
+		return true
+	}
+	return c.QueryParam("search") != "" || c.QueryParam("facets") != ""
+}
+
+// rateLimit gives every client a token bucket, keyed by its API key when it sends a known one
+// and by its IP otherwise. Expensive requests draw on a smaller bucket of their own. Every
+// throttled response carries the RateLimit headers, a refused one Retry-After as well
+func (app *app) rateLimit() echo.MiddlewareFunc {
+	var general, search *rateLimiter
+	if app.cfg.RateLimit > 0 {
+		general = newRateLimiter(app.cfg.RateLimit, app.cfg.RateBurst)
+	}
+	if app.cfg.SearchRateLimit > 0 {
+		search = newRateLimiter(app.cfg.SearchRateLimit, app.cfg.SearchRateBurst)
+	}
+	apiKeys := make(map[string]bool)
+	for _, key := range app.cfg.APIKeys {
+		apiKeys[key] = true
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if unlimitedRoutes[c.Path()] {
+				return next(c)
+			}
+			limiter := general
+			if search != nil && expensive(c) {
+				limiter = search
+			}
+			if limiter == nil {
+				return next(c)
+			}
+
+			client := "ip:" + c.RealIP()
+			if key := c.Request().Header.Get(headerAPIKey); apiKeys[key] {
+				client = "key:" + key
+			}
+
+			decision := limiter.take(client, time.Now())
+			header := c.Response().Header()
+			header.Set(headerRateLimitLimit, strconv.Itoa(decision.Limit))
+			header.Set(headerRateLimitRemaining, strconv.Itoa(decision.Remaining))
+			header.Set(headerRateLimitReset, strconv.Itoa(seconds(decision.Reset)))
+			if !decision.Allowed {
+				retry := max(seconds(decision.RetryAfter), 1)
+				header.Set(echo.HeaderRetryAfter, strconv.Itoa(retry))
+				return Error.RateLimited(fmt.Sprintf("too many requests, retry in %ds", retry))
+			}
+			return next(c)
+		}
+	}
+}
+
+const (
+	headerAPIKey             = "X-API-Key"
+	headerRateLimitLimit     = "RateLimit-Limit"
+	headerRateLimitRemaining = "RateLimit-Remaining"
+	headerRateLimitReset     = "RateLimit-Reset"
+)
+
+// seconds rounds d up to whole seconds, the unit of the RateLimit and Retry-After headers
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
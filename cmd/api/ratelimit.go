@@ -0,0 +1,79 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimiter keeps a token bucket per client. Buckets that have refilled completely are no
+// different from new ones, so they are dropped now and then to keep memory bounded
+type rateLimiter struct {
+	limit   rate.Limit
+	burst   int
+	mu      sync.Mutex
+	clients map[string]*rate.Limiter
+	swept   time.Time
+}
+
+// rateDecision is what take found for a request, Remaining and Reset feed the RateLimit headers
+type rateDecision struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// sweepInterval is how often buckets are looked at for dropping
+const sweepInterval = time.Minute
+
+func newRateLimiter(perSecond float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		limit:   rate.Limit(perSecond),
+		burst:   burst,
+		clients: make(map[string]*rate.Limiter),
+		swept:   time.Now(),
+	}
+}
+
+// take spends one token from key's bucket, or says how long until one is free
+func (l *rateLimiter) take(key string, now time.Time) rateDecision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.swept) > sweepInterval {
+		l.sweep(now)
+	}
+
+	limiter, ok := l.clients[key]
+	if !ok {
+		limiter = rate.NewLimiter(l.limit, l.burst)
+		l.clients[key] = limiter
+	}
+
+	decision := rateDecision{Limit: l.burst}
+	reservation := limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		decision.RetryAfter = delay
+	} else {
+		decision.Allowed = true
+	}
+
+	tokens := limiter.TokensAt(now)
+	decision.Remaining = int(math.Max(0, math.Floor(tokens)))
+	decision.Reset = time.Duration((float64(l.burst) - tokens) / float64(l.limit) * float64(time.Second))
+	return decision
+}
+
+func (l *rateLimiter) sweep(now time.Time) {
+	for key, limiter := range l.clients {
+		if limiter.TokensAt(now) >= float64(l.burst) {
+			delete(l.clients, key)
+		}
+	}
+	l.swept = now
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestRateLimiterTake(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		key       string
+		at        time.Duration
+		allowed   bool
+		remaining int
+		retry     time.Duration
+	}{
+		{"first request", "a", 0, true, 1, 0},
+		{"second request", "a", 0, true, 0, 0},
+		{"burst spent", "a", 0, false, 0, 500 * time.Millisecond},
+		{"other client has its own bucket", "b", 0, true, 1, 0},
+		{"a token back after refilling", "a", 500 * time.Millisecond, true, 0, 0},
+		{"bucket full again", "a", 10 * time.Second, true, 1, 0},
+	}
+
+	limiter := newRateLimiter(2, 2)
+	for _, tt := range tests {
+		decision := limiter.take(tt.key, start.Add(tt.at))
+		if decision.Allowed != tt.allowed || decision.Remaining != tt.remaining || decision.RetryAfter != tt.retry {
+			t.Errorf("%s: got %+v, want allowed %v, remaining %d, retry after %v", tt.name, decision, tt.allowed, tt.remaining, tt.retry)
+		}
+		if decision.Limit != 2 {
+			t.Errorf("%s: limit = %d, want 2", tt.name, decision.Limit)
+		}
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	start := time.Now()
+	limiter := newRateLimiter(1, 1)
+	limiter.take("a", start)
+	limiter.take("b", start.Add(sweepInterval))
+
+	// a has refilled by the time the sweep runs, b hasn't
+	limiter.take("c", start.Add(sweepInterval+time.Second/2))
+	if _, ok := limiter.clients["a"]; ok {
+		t.Error("a full bucket wasn't dropped")
+	}
+	if _, ok := limiter.clients["b"]; !ok {
+		t.Error("a bucket still refilling was dropped")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	app := testApp(t, "-rate-limit", "1", "-search-rate-limit", "1")
+	app.cfg.RateBurst = 2
+	app.cfg.SearchRateBurst = 1
+	app.cfg.APIKeys = []string{"secret"}
+
+	server := echo.New()
+	server.HTTPErrorHandler = app.httpErrorHandler
+	server.Use(app.rateLimit())
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	server.GET("/audiobooks", ok)
+	server.GET("/healthz", ok)
+
+	tests := []struct {
+		name   string
+		path   string
+		key    string
+		status int
+	}{
+		{"first request", "/audiobooks", "", 200},
+		{"search has a bucket of its own", "/audiobooks?search=whale", "", 200},
+		{"second search is refused", "/audiobooks?search=moby", "", 429},
+		{"second request", "/audiobooks", "", 200},
+		{"third request is refused", "/audiobooks", "", 429},
+		{"health is never limited", "/healthz", "", 200},
+		{"a known key has its own budget", "/audiobooks", "secret", 200},
+		{"an unknown key counts against the ip", "/audiobooks", "guess", 429},
+	}
+
+	for _, tt := range tests {
+		header := http.Header{}
+		if tt.key != "" {
+			header.Set(headerAPIKey, tt.key)
+		}
+		rec := get(server, tt.path, header)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.status)
+			continue
+		}
+		if tt.path == "/healthz" {
+			if rec.Header().Get(headerRateLimitLimit) != "" {
+				t.Errorf("%s: rate limit headers on an unlimited route", tt.name)
+			}
+			continue
+		}
+		if rec.Header().Get(headerRateLimitRemaining) == "" {
+			t.Errorf("%s: no %s header", tt.name, headerRateLimitRemaining)
+		}
+		if retry := rec.Header().Get(echo.HeaderRetryAfter); (retry != "") != (tt.status == 429) {
+			t.Errorf("%s: Retry-After = %q", tt.name, retry)
+		}
+	}
+}
+
+func TestExpensive(t *testing.T) {
+	tests := []struct {
+		route string
+		path  string
+		want  bool
+	}{
+		{"/audiobooks", "/audiobooks", false},
+		{"/audiobooks", "/audiobooks?search=whale", true},
+		{"/audiobooks", "/audiobooks?facets=all", true},
+		{"/audiobooks/:id", "/audiobooks/1", false},
+		{"/audiobooks/:id/similar", "/audiobooks/1/similar", true},
+		{"/authors", "/authors", true},
+		{"/authors/:id", "/authors/a1", false},
+		{"/stats", "/stats", true},
+	}
+
+	server := echo.New()
+	for _, tt := range tests {
+		c := server.NewContext(httptest.NewRequest(http.MethodGet, tt.path, nil), httptest.NewRecorder())
+		c.SetPath(tt.route)
+		if got := expensive(c); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
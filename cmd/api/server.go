@@ -1 +1,87 @@
 package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/mayank12gt/free-audiobooks-backend/internal/logging"
+)
+
+// newServer sets up the middleware and routes of the API
+func (app *app) newServer() *echo.Echo {
+	server := echo.New()
+	server.HTTPErrorHandler = app.httpErrorHandler
+	server.HideBanner = true
+	server.HidePort = true
+	// behind a proxy every request comes from its address, so the client's has to come from
+	// X-Forwarded-For. Without one that header is whatever the client says it is
+	server.IPExtractor = echo.ExtractIPDirect()
+	if app.cfg.TrustProxy {
+		server.IPExtractor = echo.ExtractIPFromXFFHeader()
+	}
+	server.Use(requestID())
+	server.Use(traceRequests())
+	server.Use(app.accessLog())
+	server.Use(recordMetrics())
+	// inside the logging and metrics so a panic is still logged and counted as the 500 it answers
+	server.Use(middleware.RecoverWithConfig(middleware.RecoverConfig{
+		LogErrorFunc: func(c echo.Context, err error, stack []byte) error {
+			logging.FromContext(c.Request().Context()).Error("handler panicked", "err", err, "stack", string(stack))
+			return err
+		},
+	}))
+	//server.Use(middleware.CORS())
+	server.Use(middleware.CORSWithConfig(middleware.CORSConfig{
+
+		AllowOrigins:  app.cfg.CORSOrigins,
+		AllowHeaders:  []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, headerAPIKey},
+		ExposeHeaders: []string{echo.HeaderXRequestID, headerRateLimitLimit, headerRateLimitRemaining, headerRateLimitReset, echo.HeaderRetryAfter},
+	}))
+	server.Use(app.rateLimit())
+	app.registerHandlers(server)
+	return server
+}
+
+// serve runs the server until SIGINT or SIGTERM, then stops accepting connections and waits
+// up to the shutdown timeout for in-flight requests before returning
+func (app *app) serve() error {
+	server := app.newServer()
+	server.Server.ReadTimeout = app.cfg.ReadTimeout
+	server.Server.ReadHeaderTimeout = app.cfg.ReadTimeout
+	server.Server.WriteTimeout = app.cfg.WriteTimeout
+	server.Server.IdleTimeout = app.cfg.IdleTimeout
+	drain := app.cfg.ShutdownTimeout
+
+	shutdown := make(chan error, 1)
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-quit
+		signal.Stop(quit)
+
+		app.logger.Info("shutting down, draining requests", "signal", sig.String(), "timeout", drain.String())
+		ctx, cancel := context.WithTimeout(context.Background(), drain)
+		defer cancel()
+		shutdown <- server.Shutdown(ctx)
+	}()
+
+	app.logger.Info("server starting", "port", app.cfg.Port)
+	err := server.Start(":" + app.cfg.Port)
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	if err := <-shutdown; err != nil {
+		return err
+	}
+	app.logger.Info("server stopped")
+
+	return nil
+
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/mayank12gt/free-audiobooks-backend/internal/config"
+	"github.com/mayank12gt/free-audiobooks-backend/internal/repos"
+	"github.com/mayank12gt/free-audiobooks-backend/internal/services"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// testApp is an API on the memory store with the default limits
+func testApp(t *testing.T, args ...string) *app {
+	t.Helper()
+	cfg, err := config.Load(config.API, nil, append([]string{"-store", "memory"}, args...))
+	if err != nil {
+		t.Fatalf("config: %v", err)
+	}
+	repo := repos.NewMemoryRepo([]*repos.Audiobook{
+		{IDStr: "1", Title: "Emma", Language: "English", TotalTimeSecs: 30000,
+			Description: "A young woman meddles in marriages", Genres: []repos.Genre{{ID: "g1", Name: "Romance"}},
+			Authors: []repos.Author{{ID: "a1", FirstName: "Jane", LastName: "Austen"}}},
+		{IDStr: "2", Title: "Moby Dick", Language: "English", TotalTimeSecs: 90000,
+			Description: "The hunting of a white whale", Genres: []repos.Genre{{ID: "g2", Name: "Adventure"}},
+			Authors: []repos.Author{{ID: "a2", FirstName: "Herman", LastName: "Melville"}}},
+		{IDStr: "3", Title: "The Odyssey", Language: "English", TotalTimeSecs: 10800,
+			Description: "An epic poem about a hero who hunts his way home", Genres: []repos.Genre{{ID: "g2", Name: "Adventure"}},
+			Authors: []repos.Author{{ID: "a3", LastName: "Homer"}}},
+	}, []*repos.GenreDTO{{IDStr: "g1", Name: "Romance"}, {IDStr: "g2", Name: "Adventure"}})
+	return &app{
+		cfg:      cfg,
+		logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+		services: services.NewService(repo, time.Hour),
+	}
+}
+
+// server is the API's routes on the test app
+func server(t *testing.T) *echo.Echo {
+	return testApp(t).newServer()
+}
+
+func get(handler http.Handler, path string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestListHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		status int
+		want   []string
+		fields []string
+	}{
+		{"defaults", "/audiobooks", 200, []string{"1", "2", "3"}, nil},
+		{"search", "/audiobooks?search=hunt", 200, []string{"2", "3"}, nil},
+		{"sort", "/audiobooks?sort_by=-totaltimesecs", 200, []string{"2", "1", "3"}, nil},
+		{"genre", "/audiobooks?genres=g2&sort_by=title", 200, []string{"2", "3"}, nil},
+		{"nothing matches", "/audiobooks?search=nothing", 200, []string{}, nil},
+		{"facets", "/audiobooks?facets=all", 200, []string{"1", "2", "3"}, nil},
+		{"unknown facet", "/audiobooks?facets=authors", 400, nil, []string{"facets"}},
+		{"first cursor page", "/audiobooks?cursor=&page_size=2", 200, []string{"1", "2"}, nil},
+		{"cursor with a page", "/audiobooks?cursor=&page=2", 400, nil, []string{"cursor"}},
+		{"bad cursor", "/audiobooks?cursor=%25%25", 400, nil, []string{"cursor"}},
+		{"unknown sort key", "/audiobooks?sort_by=author", 400, nil, []string{"sort_by"}},
+		{"sort key after date_added", "/audiobooks?sort_by=date_added,title", 400, nil, []string{"sort_by"}},
+		{"page below one", "/audiobooks?page=0", 400, nil, []string{"page"}},
+		{"page size over the max", "/audiobooks?page_size=1000", 400, nil, []string{"page_size"}},
+		{"page size not a number", "/audiobooks?page_size=ten", 400, nil, []string{"page_size"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := get(server(t), tt.path, nil)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != 200 {
+				var body ErrorResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+					t.Fatalf("decode: %v", err)
+				}
+				if body.Code != "validation" {
+					t.Errorf("code = %q, want validation", body.Code)
+				}
+				for _, field := range tt.fields {
+					if body.Fields[field] == "" {
+						t.Errorf("no message for %s in %v", field, body.Fields)
+					}
+				}
+				return
+			}
+			var body Response
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			got := []string{}
+			for _, a := range body.Audiobooks {
+				got = append(got, a.IDStr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if (body.Facets != nil) != strings.Contains(tt.path, "facets=") {
+				t.Errorf("facets = %+v", body.Facets)
+			}
+		})
+	}
+}
+
+func TestHandlerStatuses(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		status int
+		code   string
+	}{
+		{"book", "/audiobooks/1", 200, ""},
+		{"missing book", "/audiobooks/99", 404, "not_found"},
+		{"similar books", "/audiobooks/2/similar", 200, ""},
+		{"similar books of a missing book", "/audiobooks/99/similar", 404, "not_found"},
+		{"similar books page size over the max", "/audiobooks/2/similar?page_size=51", 400, "validation"},
+		{"liveness", "/healthz", 200, ""},
+		{"genres", "/genres", 200, ""},
+		{"genres page below one", "/genres?page=0", 400, "validation"},
+		{"stats", "/stats", 200, ""},
+		{"metrics", "/metrics", 200, ""},
+		{"authors", "/authors?search=homer", 200, ""},
+		{"author", "/authors/a1", 200, ""},
+		{"missing author", "/authors/zz", 404, "not_found"},
+		{"authors page below one", "/authors?page=0", 400, "validation"},
+		{"suggest without a query", "/suggest", 400, "validation"},
+		{"suggest limit over the max", "/suggest?q=em&limit=50", 400, "validation"},
+		{"unknown route", "/nowhere", 404, "not_found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := get(server(t), tt.path, nil)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.code == "" {
+				return
+			}
+			var body ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if body.Code != tt.code {
+				t.Errorf("code = %q, want %q", body.Code, tt.code)
+			}
+			if body.RequestID == "" || body.RequestID != rec.Header().Get(echo.HeaderXRequestID) {
+				t.Errorf("request id %q doesn't match the header %q", body.RequestID, rec.Header().Get(echo.HeaderXRequestID))
+			}
+		})
+	}
+}
+
+// TestRecover checks a panicking handler answers the usual internal error
+func TestRecover(t *testing.T) {
+	server := server(t)
+	server.GET("/panic", func(c echo.Context) error {
+		panic("boom")
+	})
+
+	rec := get(server, "/panic", nil)
+	if rec.Code != 500 {
+		t.Fatalf("status = %d, want 500: %s", rec.Code, rec.Body)
+	}
+	var body ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.Code != "internal" {
+		t.Errorf("code = %q, want internal", body.Code)
+	}
+}
+
+func TestSuggestHandler(t *testing.T) {
+	rec := get(server(t), "/suggest?q=mo", nil)
+	if rec.Code != 200 {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var body SuggestResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(body.Suggestions) == 0 || body.Suggestions[0].Text != "Moby Dick" {
+		t.Errorf("suggestions = %+v, want Moby Dick first", body.Suggestions)
+	}
+}
+
+func TestReadyHandler(t *testing.T) {
+	ok := func(ctx context.Context) (interface{}, error) { return nil, nil }
+	failing := func(ctx context.Context) (interface{}, error) {
+		return []string{"mongo.internal:27017"}, errors.New("dial mongo.internal:27017")
+	}
+
+	tests := []struct {
+		name   string
+		checks []readinessCheck
+		status int
+		want   map[string]string
+	}{
+		{"all passing", []readinessCheck{{name: "mongo", required: true, run: ok}}, 200,
+			map[string]string{"mongo": "ok"}},
+		{"optional check failing", []readinessCheck{{name: "mongo", required: true, run: ok}, {name: "indexes", run: failing}}, 200,
+			map[string]string{"mongo": "ok", "indexes": "failing"}},
+		{"required check failing", []readinessCheck{{name: "mongo", required: true, run: failing}}, 503,
+			map[string]string{"mongo": "failing"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := testApp(t)
+			app.readiness = tt.checks
+			rec := get(app.newServer(), "/readyz", nil)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			// the errors and details of a check are logged, never answered
+			if strings.Contains(rec.Body.String(), "mongo.internal") {
+				t.Errorf("body leaks check details: %s", rec.Body)
+			}
+			var body ReadyResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if !reflect.DeepEqual(body.Checks, tt.want) {
+				t.Errorf("checks = %v, want %v", body.Checks, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name string
+		sent string
+		kept bool
+	}{
+		{"none sent", "", false},
+		{"valid id is echoed", "abc-123", true},
+		{"invalid id is replaced", "bad id\n", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.sent != "" {
+				header.Set(echo.HeaderXRequestID, tt.sent)
+			}
+			got := get(server(t), "/healthz", header).Header().Get(echo.HeaderXRequestID)
+			if got == "" {
+				t.Fatal("no request id in the response")
+			}
+			if (got == tt.sent) != tt.kept {
+				t.Errorf("request id = %q, sent %q", got, tt.sent)
+			}
+		})
+	}
+}
+
+// TestMetricsPerApp builds two servers, each registers a catalog collector of its own
+func TestMetricsPerApp(t *testing.T) {
+	for i := 0; i < 2; i++ {
+		rec := get(server(t), "/metrics", nil)
+		if rec.Code != 200 {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+		}
+		if !strings.Contains(rec.Body.String(), "go_goroutines") {
+			t.Errorf("metrics are missing the process wide ones")
+		}
+	}
+}
+
+func TestMethodLabel(t *testing.T) {
+	tests := []struct {
+		method string
+		want   string
+	}{
+		{"GET", "GET"},
+		{"options", "other"},
+		{"PATCH", "PATCH"},
+		{"FOOBAR", "other"},
+		{"", "other"},
+	}
+
+	for _, tt := range tests {
+		if got := methodLabel(tt.method); got != tt.want {
+			t.Errorf("methodLabel(%q) = %q, want %q", tt.method, got, tt.want)
+		}
+	}
+}
+
+func TestTraceSpanName(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	req := httptest.NewRequest("FOOBAR", "/audiobooks", nil)
+	server(t).ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) == 0 {
+		t.Fatal("no span recorded")
+	}
+	if got := spans[len(spans)-1].Name(); got != "other /audiobooks" {
+		t.Errorf("span name = %q, want %q", got, "other /audiobooks")
+	}
+}
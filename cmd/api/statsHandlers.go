@@ -0,0 +1,17 @@
+package main
+
+import (
+	"github.com/labstack/echo/v4"
+)
+
+func (app *app) StatsHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+
+		stats, err := app.services.AudiobooksService.Stats(c.Request().Context())
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(200, stats)
+	}
+}
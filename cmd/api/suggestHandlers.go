@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	Error "github.com/mayank12gt/free-audiobooks-backend/internal/errors"
+	"github.com/mayank12gt/free-audiobooks-backend/internal/repos"
+)
+
+type SuggestResponse struct {
+	Suggestions []*repos.Suggestion `json:"suggestions"`
+}
+
+func (app *app) SuggestHandler() func(c echo.Context) error {
+	return func(c echo.Context) error {
+
+		q := c.QueryParam("q")
+		if q == "" {
+			return Error.NewError().Set("q", "must not be empty")
+		}
+		if paramErr := app.limits().CheckSearch("q", q); paramErr != nil {
+			return paramErr
+		}
+
+		limit := 10
+		if c.QueryParam("limit") != "" {
+			var err error
+			limit, err = strconv.Atoi(c.QueryParam("limit"))
+			if err != nil {
+				return Error.NewError().Set("limit", "Must be an integer")
+			}
+		}
+		if limit > 20 || limit < 1 {
+			return Error.NewError().Set("limit", "max value is 20 and min value is 1")
+		}
+
+		suggestions, err := app.services.AudiobooksService.Suggest(c.Request().Context(), q, limit)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(200, SuggestResponse{
+			Suggestions: suggestions,
+		})
+	}
+}
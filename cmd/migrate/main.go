@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/mayank12gt/free-audiobooks-backend/internal/config"
+	"github.com/mayank12gt/free-audiobooks-backend/internal/logging"
+	"github.com/mayank12gt/free-audiobooks-backend/internal/migrations"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func main() {
+
+	if len(os.Args) < 2 {
+		logging.Fatal("expected a command: up, down, status or unlock")
+	}
+	command, args := os.Args[1], os.Args[2:]
+
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	to := flags.Int("to", 0, "up: stop after this version, 0 applies every pending migration")
+	steps := flags.Int("steps", 1, "down: how many migrations to revert")
+
+	cfg, err := config.Load(config.Migrate, flags, args)
+	if err != nil {
+		logging.Fatal("can't load the configuration", "err", err)
+	}
+	logging.New(os.Stderr, cfg.LogLevel, cfg.LogFormat)
+
+	db, err := openDB(cfg)
+	if err != nil {
+		logging.Fatal("can't connect to MongoDB", "err", err)
+	}
+	defer func() {
+		if err := db.Client().Disconnect(context.TODO()); err != nil {
+			slog.Error("DB disconnect failed", "err", err)
+		}
+	}()
+
+	ctx := context.Background()
+
+	switch command {
+	case "up":
+		locked(ctx, db, func() error {
+			applied, err := migrations.Up(ctx, db, *to)
+			for _, m := range applied {
+				slog.Info("migration applied", "version", m.Version, "description", m.Description)
+			}
+			if err == nil && len(applied) == 0 {
+				slog.Info("nothing to migrate")
+			}
+			return err
+		})
+	case "down":
+		locked(ctx, db, func() error {
+			reverted, err := migrations.Down(ctx, db, *steps)
+			for _, m := range reverted {
+				slog.Info("migration reverted", "version", m.Version, "description", m.Description)
+			}
+			return err
+		})
+	case "status":
+		status(ctx, db)
+	case "unlock":
+		if err := migrations.Unlock(ctx, db); err != nil {
+			logging.Fatal("unlock failed", "err", err)
+		}
+		slog.Info("lock released")
+	default:
+		logging.Fatal("unknown command, expected up, down, status or unlock", "command", command)
+	}
+
+}
+
+// locked runs fn holding the migration lock so two instances never migrate at once
+func locked(ctx context.Context, db *mongo.Database, fn func() error) {
+	if err := migrations.Lock(ctx, db); err != nil {
+		logging.Fatal("can't take the migration lock, run unlock if that instance is gone", "err", err)
+	}
+	err := fn()
+	if unlockErr := migrations.Unlock(ctx, db); unlockErr != nil {
+		slog.Error("unlock failed", "err", unlockErr)
+	}
+	if err != nil {
+		logging.Fatal("migration failed", "err", err)
+	}
+}
+
+func status(ctx context.Context, db *mongo.Database) {
+	records, err := migrations.Applied(ctx, db)
+	if err != nil {
+		logging.Fatal("can't read the applied migrations", "err", err)
+	}
+	applied := make(map[int]migrations.Record)
+	for _, r := range records {
+		applied[r.Version] = r
+	}
+
+	for _, m := range migrations.All() {
+		state := "pending"
+		if r, ok := applied[m.Version]; ok {
+			state = "applied " + r.AppliedAt.Format(time.RFC3339)
+			delete(applied, m.Version)
+		}
+		fmt.Printf("%4d  %-28s %s\n", m.Version, state, m.Description)
+	}
+	for _, r := range records {
+		if _, ok := applied[r.Version]; ok {
+			fmt.Printf("%4d  %-28s %s (unknown to this build)\n", r.Version, "applied "+r.AppliedAt.Format(time.RFC3339), r.Description)
+		}
+	}
+}
+
+func openDB(cfg *config.Config) (*mongo.Database, error) {
+	opts := options.Client().ApplyURI(cfg.DSN)
+	client, err := mongo.Connect(context.TODO(), opts)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("DB connected", "db", cfg.Database)
+	return client.Database(cfg.Database), nil
+}
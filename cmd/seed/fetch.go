@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mayank12gt/free-audiobooks-backend/internal/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/time/rate"
+)
+
+// errEndOfCatalog means LibriVox has no books past the requested offset
+var errEndOfCatalog = errors.New("end of catalog")
+
+// permanentError is a failure that retrying the same request won't fix
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+// FailedPage is a page that could not be fetched, kept so a later run can retry it
+type FailedPage struct {
+	Offset   int       `bson:"offset"`
+	Limit    int       `bson:"limit"`
+	Since    time.Time `bson:"since"`
+	Error    string    `bson:"error"`
+	Attempts int       `bson:"attempts"`
+	FailedAt time.Time `bson:"failed_at"`
+}
+
+type fetcher struct {
+	client     *http.Client
+	limiter    *rate.Limiter
+	maxRetries int
+	retryDelay time.Duration
+}
+
+func newFetcher(cfg config.SeedConfig) *fetcher {
+	limit := rate.Inf
+	if cfg.RequestsPerSecond > 0 {
+		limit = rate.Limit(cfg.RequestsPerSecond)
+	}
+	return &fetcher{
+		client:     &http.Client{Timeout: cfg.RequestTimeout},
+		limiter:    rate.NewLimiter(limit, 1),
+		maxRetries: cfg.MaxRetries,
+		retryDelay: cfg.RetryDelay,
+	}
+}
+
+// getPage fetches one page of books, retrying transient failures with exponential backoff.
+// It returns errEndOfCatalog once the offset is past the last book
+func (f *fetcher) getPage(baseURL string, limit, offset int, since time.Time) (Res, error) {
+
+	reqString := fmt.Sprintf("%s?limit=%d&offset=%d&format=json&extended=1", baseURL, limit, offset)
+	if !since.IsZero() {
+		reqString += fmt.Sprintf("&since=%d", since.Unix())
+	}
+
+	var response Res
+	err := f.retry(reqString, func() (time.Duration, error) {
+		var retryAfter time.Duration
+		var err error
+		response, retryAfter, err = f.fetch(reqString)
+		return retryAfter, err
+	})
+	return response, err
+}
+
+// getFeed downloads an RSS feed with the same rate limit and retries as catalog pages
+func (f *fetcher) getFeed(url string) ([]byte, error) {
+	var body []byte
+	err := f.retry(url, func() (time.Duration, error) {
+		res, err := f.client.Get(url)
+		if err != nil {
+			return 0, err
+		}
+		defer res.Body.Close()
+
+		retryAfter, err := checkStatus(res)
+		if err != nil {
+			return retryAfter, err
+		}
+		body, err = io.ReadAll(res.Body)
+		return 0, err
+	})
+	return body, err
+}
+
+// retry runs attempt until it succeeds, fails permanently, reports the end of the catalog or
+// runs out of retries. attempt may ask for a longer wait by returning a Retry-After delay
+func (f *fetcher) retry(description string, attempt func() (time.Duration, error)) error {
+	for n := 0; ; n++ {
+		if err := f.limiter.Wait(context.Background()); err != nil {
+			return err
+		}
+
+		slog.Debug(description)
+
+		retryAfter, err := attempt()
+		if err == nil || errors.Is(err, errEndOfCatalog) {
+			librivoxRequests.WithLabelValues("ok").Inc()
+			return err
+		}
+
+		var permanent permanentError
+		if errors.As(err, &permanent) || n == f.maxRetries {
+			librivoxRequests.WithLabelValues("failed").Inc()
+			return err
+		}
+		librivoxRequests.WithLabelValues("retried").Inc()
+
+		delay := f.retryDelay * time.Duration(1<<n)
+		delay += time.Duration(rand.Int63n(int64(delay)/2 + 1))
+		if retryAfter > delay {
+			delay = retryAfter
+		}
+		slog.Warn(description+" failed", "err", err, "retry", n+1, "retries", f.maxRetries, "delay", delay)
+		time.Sleep(delay)
+	}
+}
+
+// checkStatus sorts non 200 responses into retryable and permanent failures
+func checkStatus(res *http.Response) (time.Duration, error) {
+	switch {
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+		retryAfter, _ := strconv.Atoi(res.Header.Get("Retry-After"))
+		return time.Duration(retryAfter) * time.Second, fmt.Errorf("unexpected status %d", res.StatusCode)
+	case res.StatusCode != http.StatusOK:
+		return 0, permanentError{fmt.Errorf("unexpected status %d", res.StatusCode)}
+	}
+	return 0, nil
+}
+
+func (f *fetcher) fetch(reqString string) (Res, time.Duration, error) {
+
+	res, err := f.client.Get(reqString)
+	if err != nil {
+		return Res{}, 0, err
+	}
+	defer res.Body.Close()
+
+	responseData, err := io.ReadAll(res.Body)
+	if err != nil {
+		return Res{}, 0, err
+	}
+
+	if res.StatusCode == http.StatusNotFound {
+		// LibriVox answers past the end of the catalog with a 404 and an error message
+		var notFound struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(responseData, &notFound) == nil && notFound.Error != "" {
+			return Res{}, 0, errEndOfCatalog
+		}
+	}
+	if retryAfter, err := checkStatus(res); err != nil {
+		return Res{}, retryAfter, err
+	}
+
+	var response Res
+	if err := json.Unmarshal(responseData, &response); err != nil {
+		return Res{}, 0, fmt.Errorf("bad response body: %w", err)
+	}
+	if len(response.Books) == 0 {
+		return Res{}, 0, errEndOfCatalog
+	}
+
+	return response, 0, nil
+}
+
+// recordFailedPage stores a page that ran out of retries, replacing any earlier record of it
+func recordFailedPage(collection *mongo.Collection, page FailedPage) error {
+	page.FailedAt = time.Now()
+	_, err := collection.UpdateOne(context.Background(),
+		bson.D{{Key: "offset", Value: page.Offset}, {Key: "limit", Value: page.Limit}, {Key: "since", Value: page.Since}},
+		bson.D{
+			{Key: "$set", Value: bson.D{{Key: "error", Value: page.Error}, {Key: "failed_at", Value: page.FailedAt}}},
+			{Key: "$inc", Value: bson.D{{Key: "attempts", Value: 1}}},
+		},
+		options.Update().SetUpsert(true))
+	return err
+}
+
+func clearFailedPage(collection *mongo.Collection, page FailedPage) error {
+	_, err := collection.DeleteOne(context.Background(),
+		bson.D{{Key: "offset", Value: page.Offset}, {Key: "limit", Value: page.Limit}, {Key: "since", Value: page.Since}})
+	return err
+}
+
+func failedPages(collection *mongo.Collection) ([]FailedPage, error) {
+	cursor, err := collection.Find(context.Background(), bson.D{}, options.Find().SetSort(bson.D{{Key: "offset", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	var pages []FailedPage
+	if err := cursor.All(context.Background(), &pages); err != nil {
+		return nil, err
+	}
+	return pages, nil
+}
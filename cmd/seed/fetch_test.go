@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mayank12gt/free-audiobooks-backend/internal/config"
+)
+
+// testFetcher retries twice with next to no delay and no rate limit
+func testFetcher() *fetcher {
+	return newFetcher(config.SeedConfig{MaxRetries: 2, RetryDelay: time.Millisecond, RequestTimeout: time.Second})
+}
+
+// response is one answer of a test server
+type response struct {
+	status     int
+	retryAfter string
+	body       string
+}
+
+// serve answers requests with responses in order, repeating the last one once they run out
+func serve(t *testing.T, responses ...response) (*httptest.Server, *int32) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(&requests, 1)) - 1
+		if n >= len(responses) {
+			n = len(responses) - 1
+		}
+		if responses[n].retryAfter != "" {
+			w.Header().Set("Retry-After", responses[n].retryAfter)
+		}
+		w.WriteHeader(responses[n].status)
+		w.Write([]byte(responses[n].body))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+const onePage = `{"books":[{"id":"1","title":"Emma"}]}`
+
+func TestGetPage(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses []response
+		books     int
+		err       error
+		permanent bool
+		requests  int32
+	}{
+		{"page", []response{{200, "", onePage}}, 1, nil, false, 1},
+		{"404 with an error is the end", []response{{404, "", `{"error":"No audiobooks could be found"}`}}, 0, errEndOfCatalog, false, 1},
+		{"empty page is the end", []response{{200, "", `{"books":[]}`}}, 0, errEndOfCatalog, false, 1},
+		{"404 without an error is permanent", []response{{404, "", "not here"}}, 0, nil, true, 1},
+		{"400 is permanent", []response{{400, "", ""}}, 0, nil, true, 1},
+		{"5xx is retried", []response{{503, "", ""}, {200, "", onePage}}, 1, nil, false, 2},
+		{"429 is retried", []response{{429, "0", ""}, {200, "", onePage}}, 1, nil, false, 2},
+		{"gives up after the retries", []response{{500, "", ""}}, 0, nil, false, 3},
+		{"bad body is retried", []response{{200, "", "{"}, {200, "", onePage}}, 1, nil, false, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := serve(t, tt.responses...)
+			res, err := testFetcher().getPage(server.URL, 10, 0, time.Time{})
+
+			if got := atomic.LoadInt32(requests); got != tt.requests {
+				t.Errorf("requests = %d, want %d", got, tt.requests)
+			}
+			if len(res.Books) != tt.books {
+				t.Errorf("books = %d, want %d", len(res.Books), tt.books)
+			}
+			var permanent permanentError
+			if errors.As(err, &permanent) != tt.permanent {
+				t.Errorf("err = %v, want permanent %v", err, tt.permanent)
+			}
+			switch {
+			case tt.err != nil && !errors.Is(err, tt.err):
+				t.Errorf("err = %v, want %v", err, tt.err)
+			case tt.err == nil && tt.books > 0 && err != nil:
+				t.Errorf("err = %v", err)
+			case tt.books == 0 && err == nil:
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestGetPageQuery(t *testing.T) {
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		w.Write([]byte(onePage))
+	}))
+	defer server.Close()
+
+	since := time.Unix(1700000000, 0)
+	if _, err := testFetcher().getPage(server.URL, 50, 100, since); err != nil {
+		t.Fatalf("getPage: %v", err)
+	}
+	if want := "limit=50&offset=100&format=json&extended=1&since=1700000000"; query != want {
+		t.Errorf("query = %q, want %q", query, want)
+	}
+}
+
+func TestCheckStatus(t *testing.T) {
+	tests := []struct {
+		status     int
+		retryAfter string
+		ok         bool
+		permanent  bool
+		wait       time.Duration
+	}{
+		{200, "", true, false, 0},
+		{429, "7", false, false, 7 * time.Second},
+		{429, "soon", false, false, 0},
+		{500, "", false, false, 0},
+		{503, "2", false, false, 2 * time.Second},
+		{301, "", false, true, 0},
+		{403, "", false, true, 0},
+		{404, "", false, true, 0},
+	}
+
+	for _, tt := range tests {
+		res := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+		if tt.retryAfter != "" {
+			res.Header.Set("Retry-After", tt.retryAfter)
+		}
+		wait, err := checkStatus(res)
+		var permanent permanentError
+		if (err == nil) != tt.ok || errors.As(err, &permanent) != tt.permanent || wait != tt.wait {
+			t.Errorf("status %d: got wait %v, err %v", tt.status, wait, err)
+		}
+	}
+}
+
+func TestGetFeed(t *testing.T) {
+	server, requests := serve(t, response{502, "", ""}, response{200, "", "<rss/>"})
+
+	body, err := testFetcher().getFeed(server.URL)
+	if err != nil {
+		t.Fatalf("getFeed: %v", err)
+	}
+	if string(body) != "<rss/>" || atomic.LoadInt32(requests) != 2 {
+		t.Errorf("body %q after %d requests", body, atomic.LoadInt32(requests))
+	}
+}
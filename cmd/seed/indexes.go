@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/mayank12gt/free-audiobooks-backend/internal/config"
+	"github.com/mayank12gt/free-audiobooks-backend/internal/logging"
+	"github.com/mayank12gt/free-audiobooks-backend/internal/repos"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// reconcileIndexes brings the live collections in line with repos.IndexSpec
+func reconcileIndexes(cfg config.SeedConfig, db *mongo.Database) {
+	for collection, models := range repos.IndexSpec() {
+		if cfg.DryRun {
+			report, err := repos.CheckIndexes(context.Background(), db.Collection(collection), models)
+			if err != nil {
+				logging.Fatal("index check failed", "collection", collection, "err", err)
+			}
+			logIndexReport(report, "would create", "would recreate", "would drop")
+			continue
+		}
+
+		report, err := repos.ReconcileIndexes(context.Background(), db.Collection(collection), models, cfg.PruneIndexes)
+		if err != nil {
+			logging.Fatal("index reconcile failed", "collection", collection, "err", err)
+		}
+		logIndexReport(report, "created", "recreated", "dropped")
+	}
+}
+
+func logIndexReport(report repos.IndexReport, created, recreated, dropped string) {
+	for _, name := range report.Missing {
+		slog.Info(created+" index", "collection", report.Collection, "index", name)
+	}
+	for _, name := range report.Changed {
+		slog.Info(recreated+" index", "collection", report.Collection, "index", name)
+	}
+	for _, name := range report.Extra {
+		slog.Warn("index is not in the spec", "collection", report.Collection, "index", name)
+	}
+	if report.OK() && len(report.Extra) == 0 {
+		slog.Info("indexes match the spec", "collection", report.Collection)
+	}
+}
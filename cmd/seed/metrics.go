@@ -0,0 +1,70 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/mayank12gt/free-audiobooks-backend/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// the seeder exits before anything could scrape it, so a run's metrics are pushed to a Pushgateway
+// when it ends. They live in their own registry to keep the process and Go metrics out
+var registry = prometheus.NewRegistry()
+
+var (
+	lastSuccess = promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		Namespace: "audiobooks",
+		Name:      "seed_last_success_timestamp_seconds",
+		Help:      "When the last seeder run finished without failing.",
+	})
+
+	runDuration = promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		Namespace: "audiobooks",
+		Name:      "seed_run_duration_seconds",
+		Help:      "How long the last seeder run took.",
+	})
+
+	seededBooks = promauto.With(registry).NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "audiobooks",
+		Name:      "seed_books",
+		Help:      "Books the last seeder run wrote, by result.",
+	}, []string{"result"})
+
+	collectionBooks = promauto.With(registry).NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "audiobooks",
+		Name:      "seed_collection_books",
+		Help:      "Books in the main and incomplete collections after the last seeder run.",
+	}, []string{"collection"})
+
+	failedPageCount = promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		Namespace: "audiobooks",
+		Name:      "seed_failed_pages",
+		Help:      "Catalog pages the last seeder run gave up on.",
+	})
+
+	librivoxRequests = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: "audiobooks",
+		Name:      "seed_librivox_requests_total",
+		Help:      "Requests the last seeder run made to LibriVox, by outcome: ok, retried or failed.",
+	}, []string{"outcome"})
+)
+
+// pushMetrics sends the run's metrics to the Pushgateway, replacing those of the previous run.
+// A failed push is logged, it doesn't fail a run that did its work
+func pushMetrics(cfg config.SeedConfig, started time.Time) {
+	if cfg.PushgatewayURL == "" {
+		return
+	}
+
+	runDuration.Set(time.Since(started).Seconds())
+	lastSuccess.SetToCurrentTime()
+
+	if err := push.New(cfg.PushgatewayURL, "audiobooks_seed").Gatherer(registry).Push(); err != nil {
+		slog.Error("pushing metrics failed", "url", cfg.PushgatewayURL, "err", err)
+		return
+	}
+	slog.Info("metrics pushed", "url", cfg.PushgatewayURL)
+}
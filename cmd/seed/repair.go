@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mayank12gt/free-audiobooks-backend/internal/logging"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RepairReport records why a book in the incomplete collection could not be given a duration
+type RepairReport struct {
+	ID        string    `bson:"id"`
+	Title     string    `bson:"title"`
+	Reason    string    `bson:"reason"`
+	CheckedAt time.Time `bson:"checked_at"`
+}
+
+type rssFeed struct {
+	Channel struct {
+		Items []struct {
+			Title    string `xml:"title"`
+			Duration string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+		} `xml:"item"`
+	} `xml:"channel"`
+}
+
+// parseDuration reads a LibriVox playtime, either plain seconds or [[HH:]MM:]SS
+func parseDuration(s string) (int, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, false
+	}
+
+	total := 0
+	for _, part := range strings.Split(s, ":") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return 0, false
+		}
+		total = total*60 + n
+	}
+	return total, total > 0
+}
+
+// formatDuration renders seconds the way LibriVox fills totaltime
+func formatDuration(secs int) string {
+	return fmt.Sprintf("%02d:%02d:%02d", secs/3600, secs/60%60, secs%60)
+}
+
+// durationFromSections adds up the section playtimes, every section has to have one
+func durationFromSections(book Audiobook) (int, string) {
+	if len(book.Sections) == 0 {
+		return 0, "no sections"
+	}
+	if n, err := strconv.Atoi(book.NumSections); err == nil && n != len(book.Sections) {
+		return 0, fmt.Sprintf("%d of %d sections listed", len(book.Sections), n)
+	}
+
+	total := 0
+	for _, section := range book.Sections {
+		secs, ok := parseDuration(section.Playtime)
+		if !ok {
+			return 0, fmt.Sprintf("section %s has no playtime", section.SectionNumber)
+		}
+		total += secs
+	}
+	return total, ""
+}
+
+// durationFromFeed adds up the item durations of the book's RSS feed
+func (s *seeder) durationFromFeed(book Audiobook) (int, string) {
+	if book.URLRSS == "" {
+		return 0, "no rss feed"
+	}
+
+	body, err := s.fetcher.getFeed(book.URLRSS)
+	if err != nil {
+		return 0, "rss: " + err.Error()
+	}
+
+	var feed rssFeed
+	if err := xml.Unmarshal(body, &feed); err != nil {
+		return 0, "rss: bad feed: " + err.Error()
+	}
+	if len(feed.Channel.Items) == 0 {
+		return 0, "rss: feed has no items"
+	}
+
+	total := 0
+	for i, item := range feed.Channel.Items {
+		secs, ok := parseDuration(item.Duration)
+		if !ok {
+			return 0, fmt.Sprintf("rss: item %d has no duration", i+1)
+		}
+		total += secs
+	}
+	return total, ""
+}
+
+// toRepair picks the books worth another try: the ones fetched again in this run, which may have
+// changed, and the ones never tried. A book that already has a report and wasn't fetched again
+// would fail for the same reason
+func toRepair(books []Audiobook, fetched, reported map[string]bool) []Audiobook {
+	var picked []Audiobook
+	for _, book := range books {
+		if fetched[book.ID] || !reported[book.ID] {
+			picked = append(picked, book)
+		}
+	}
+	return picked
+}
+
+// reportedIDs returns the ids of the books that have a repair report
+func reportedIDs(reports *mongo.Collection) (map[string]bool, error) {
+	ids, err := reports.Distinct(context.Background(), "id", bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	reported := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if id, ok := id.(string); ok {
+			reported[id] = true
+		}
+	}
+	return reported, nil
+}
+
+// repair derives the duration of books in the incomplete collection that are worth another try,
+// moves the ones it can fix into the main collection and records the reason for the rest in the
+// repair collection
+func (s *seeder) repair(reports *mongo.Collection) (int64, int64) {
+	ctx := context.Background()
+
+	cursor, err := s.incomplete.Find(ctx, bson.D{})
+	if err != nil {
+		logging.Fatal("reading incomplete books failed", "err", err)
+	}
+	var books []Audiobook
+	if err := cursor.All(ctx, &books); err != nil {
+		logging.Fatal("reading incomplete books failed", "err", err)
+	}
+	reported, err := reportedIDs(reports)
+	if err != nil {
+		logging.Fatal("reading repair reports failed", "err", err)
+	}
+	picked := toRepair(books, s.fetched, reported)
+	slog.Info("trying to repair incomplete books", "books", len(picked), "skipped", len(books)-len(picked))
+	books = picked
+
+	var repaired, unrepairable int64
+	for _, book := range books {
+		secs, reason := durationFromSections(book)
+		if secs == 0 && s.cfg.RepairRSS {
+			var rssReason string
+			secs, rssReason = s.durationFromFeed(book)
+			if secs == 0 {
+				reason = reason + "; " + rssReason
+			}
+		}
+
+		if secs == 0 {
+			unrepairable++
+			slog.Debug("can't repair book", "id", book.ID, "title", book.Title, "reason", reason)
+			if s.cfg.DryRun {
+				continue
+			}
+			_, err := reports.ReplaceOne(ctx, bson.D{{Key: "id", Value: book.ID}},
+				RepairReport{ID: book.ID, Title: book.Title, Reason: reason, CheckedAt: time.Now()},
+				options.Replace().SetUpsert(true))
+			if err != nil {
+				logging.Fatal("writing the repair report failed", "id", book.ID, "err", err)
+			}
+			continue
+		}
+
+		repaired++
+		book.TotalTimeSecs = secs
+		book.TotalTime = formatDuration(secs)
+		if s.cfg.DryRun {
+			continue
+		}
+		if _, err := upsertBooks(s.main, s.incomplete, []Audiobook{book}); err != nil {
+			logging.Fatal("moving a repaired book failed", "id", book.ID, "err", err)
+		}
+		if _, err := reports.DeleteOne(ctx, bson.D{{Key: "id", Value: book.ID}}); err != nil {
+			logging.Fatal("clearing the repair report failed", "id", book.ID, "err", err)
+		}
+	}
+
+	if s.cfg.DryRun {
+		slog.Info("dry run repair finished", "would_repair", repaired, "unrepairable", unrepairable)
+	} else {
+		slog.Info("repair finished", "repaired", repaired, "unrepairable", unrepairable, "collection", s.cfg.IncompleteCollection)
+	}
+	return repaired, unrepairable
+}
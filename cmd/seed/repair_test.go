@@ -0,0 +1,108 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+		ok   bool
+	}{
+		{"754", 754, true},
+		{"12:34", 754, true},
+		{"01:02:03", 3723, true},
+		{" 00:10:00 ", 600, true},
+		{"", 0, false},
+		{"00:00:00", 0, false},
+		{"1:-5", 0, false},
+		{"ten", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseDuration(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseDuration(%q) = %d, %v, want %d, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	if got := formatDuration(3723); got != "01:02:03" {
+		t.Errorf("formatDuration(3723) = %q", got)
+	}
+	if got := formatDuration(100 * 3600); got != "100:00:00" {
+		t.Errorf("formatDuration(100h) = %q", got)
+	}
+}
+
+func TestDurationFromSections(t *testing.T) {
+	tests := []struct {
+		name   string
+		book   Audiobook
+		want   int
+		reason string
+	}{
+		{"every section has a playtime", Audiobook{NumSections: "2", Sections: []Section{{Playtime: "600"}, {Playtime: "00:10:00"}}}, 1200, ""},
+		{"no sections", Audiobook{NumSections: "2"}, 0, "no sections"},
+		{"sections missing", Audiobook{NumSections: "3", Sections: []Section{{Playtime: "600"}}}, 0, "1 of 3 sections listed"},
+		{"section without a playtime", Audiobook{Sections: []Section{{Playtime: "600"}, {SectionNumber: "2"}}}, 0, "section 2 has no playtime"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reason := durationFromSections(tt.book)
+			if got != tt.want || reason != tt.reason {
+				t.Errorf("got %d, %q, want %d, %q", got, reason, tt.want, tt.reason)
+			}
+		})
+	}
+}
+
+func TestDurationFromFeed(t *testing.T) {
+	const feed = `<rss xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd"><channel>
+<item><title>1</title><itunes:duration>00:10:00</itunes:duration></item>
+<item><title>2</title><itunes:duration>300</itunes:duration></item>
+</channel></rss>`
+
+	tests := []struct {
+		name      string
+		responses []response
+		want      int
+		reason    string
+	}{
+		{"items are added up", []response{{200, "", feed}}, 900, ""},
+		{"feed without items", []response{{200, "", "<rss><channel></channel></rss>"}}, 0, "rss: feed has no items"},
+		{"item without a duration", []response{{200, "", "<rss><channel><item><title>1</title></item></channel></rss>"}}, 0, "rss: item 1 has no duration"},
+		{"feed is gone", []response{{404, "", ""}}, 0, "rss: unexpected status 404"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _ := serve(t, tt.responses...)
+			s := &seeder{fetcher: testFetcher()}
+			got, reason := s.durationFromFeed(Audiobook{URLRSS: server.URL})
+			if got != tt.want || reason != tt.reason {
+				t.Errorf("got %d, %q, want %d, %q", got, reason, tt.want, tt.reason)
+			}
+		})
+	}
+
+	s := &seeder{fetcher: testFetcher()}
+	if _, reason := s.durationFromFeed(Audiobook{}); reason != "no rss feed" {
+		t.Errorf("reason without a feed = %q", reason)
+	}
+}
+
+func TestToRepair(t *testing.T) {
+	books := []Audiobook{{ID: "new"}, {ID: "refetched"}, {ID: "reported"}}
+	fetched := map[string]bool{"refetched": true}
+	reported := map[string]bool{"refetched": true, "reported": true}
+
+	var got []string
+	for _, book := range toRepair(books, fetched, reported) {
+		got = append(got, book.ID)
+	}
+	if want := []string{"new", "refetched"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -2,15 +2,17 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
-	"io"
-	"log"
-	"net/http"
+	"errors"
+	"log/slog"
 	"os"
+	"slices"
+	"strings"
 	"time"
 
-	"github.com/joho/godotenv"
+	"github.com/mayank12gt/free-audiobooks-backend/internal/config"
+	"github.com/mayank12gt/free-audiobooks-backend/internal/logging"
+	"github.com/mayank12gt/free-audiobooks-backend/internal/migrations"
+	"github.com/mayank12gt/free-audiobooks-backend/internal/repos"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -71,146 +73,516 @@ type Section struct {
 	Playtime      string `bson:"playtime" json:"playtime"`
 }
 
-type Meta struct {
-	TotalRecords int64     `bson:"total_records"`
-	LastUpdated  time.Time `bson:"last_updated"`
+// SyncState remembers when the last complete run started so the next one only asks for newer books
+type SyncState struct {
+	ID         string    `bson:"_id"`
+	LastSynced time.Time `bson:"last_synced"`
+}
+
+// StageState remembers when the stage collection was started, so a leftover stage isn't continued forever
+type StageState struct {
+	ID      string    `bson:"_id"`
+	Started time.Time `bson:"started"`
+}
+
+// ids of the sync_state documents
+const (
+	syncStateID  = "librivox"
+	stageStateID = "stage"
+)
+
+// Counts tallies what an upsert batch did
+type Counts struct {
+	Inserted  int64
+	Updated   int64
+	Unchanged int64
+}
+
+func (c *Counts) Add(o Counts) {
+	c.Inserted += o.Inserted
+	c.Updated += o.Updated
+	c.Unchanged += o.Unchanged
 }
 
 func main() {
 
-	if err := godotenv.Load(); err != nil {
-		log.Print("no env file found")
+	// the first argument picks the command, ingest when it's left out
+	command, args := "ingest", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
 	}
 
-	dsn := os.Getenv("DSN")
-	if dsn == "" {
-		log.Print("No DSN found")
+	cfg, err := config.Load(config.Seed, nil, args)
+	if err != nil {
+		logging.Fatal("can't load the configuration", "err", err)
 	}
+	logging.New(os.Stderr, cfg.LogLevel, cfg.LogFormat)
 
-	db, err := openDB(dsn)
+	db, err := openDB(cfg)
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal("can't connect to MongoDB", "err", err)
 	}
 	defer func() {
 		if err := db.Client().Disconnect(context.TODO()); err != nil {
-			log.Panic(err)
+			slog.Error("DB disconnect failed", "err", err)
 		}
 	}()
 
-	collection_main := db.Collection("seed_stage")
-	collection_incomplete := db.Collection("incomplete")
+	// a newer schema may store books in a shape this seeder would write over wrongly
+	if _, err := migrations.Check(context.Background(), db); err != nil {
+		logging.Fatal("schema check failed", "err", err)
+	}
+
+	switch command {
+	case "ingest":
+		ingest(cfg.Seed, db)
+	case "rollback":
+		rollback(cfg.Seed, db)
+	case "indexes":
+		reconcileIndexes(cfg.Seed, db)
+	default:
+		logging.Fatal("unknown command, expected ingest, rollback or indexes", "command", command)
+	}
+
+}
 
-	var limit = 500
-	var offset = 2000
-	var len int = 500
-	var cnt int = 0
-	var response Res
+// ingest fetches the catalog into the stage collection, validates it and swaps it in as the live catalog
+func ingest(cfg config.SeedConfig, db *mongo.Database) {
+	started := time.Now()
 
-	for len == limit {
-		response, len = getPage(limit, offset)
-		if len == 0 {
+	collection_main := db.Collection(cfg.StageCollection)
+	collection_incomplete := db.Collection(cfg.IncompleteCollection)
+
+	if cfg.DryRun {
+		compare, err := dryRunCollection(db, cfg)
+		if err != nil {
+			logging.Fatal("finding the collection to compare with failed", "err", err)
+		}
+		collection_main = db.Collection(compare)
+		slog.Info("dry run, nothing will be written", "compared_with", compare)
+	} else if err := prepareStage(db, cfg); err != nil {
+		logging.Fatal("preparing the stage collection failed", "err", err)
+	}
+
+	for _, collection := range []*mongo.Collection{collection_main, collection_incomplete} {
+		if cfg.DryRun {
 			break
 		}
-		log.Printf("%d records fetched\n", len)
-		// for _, book := range response.Books {
-		// 	_, err := collection.InsertOne(context.Background(), book)
-		// 	if err != nil {
-		// 		log.Fatal(err)
-		// 	}
-		// }
+		_, err := collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+			Keys:    bson.D{{Key: "id", Value: 1}},
+			Options: options.Index().SetName("id_unique").SetUnique(true),
+		})
+		if err != nil {
+			logging.Fatal("creating the id index failed", "collection", collection.Name(), "err", err)
+		}
+	}
 
-		var recordsToInsertMain []interface{}
-		var recordsToInsertIncomplete []interface{}
+	s := &seeder{
+		cfg:        cfg,
+		main:       collection_main,
+		incomplete: collection_incomplete,
+		failed:     db.Collection(cfg.FailedCollection),
+		fetcher:    newFetcher(cfg),
+		fetched:    map[string]bool{},
+	}
+
+	if cfg.RetryFailed {
+		s.retryFailedPages()
+	} else {
+		since, err := lastSynced(db)
+		if err != nil {
+			logging.Fatal("reading the last sync time failed", "err", err)
+		}
+		if since.IsZero() {
+			slog.Info("no previous sync found, fetching the whole catalog")
+		} else {
+			slog.Info("fetching changed books", "since", since.Format(time.RFC3339))
+		}
 
-		for _, book := range response.Books {
-			if book.TotalTimeSecs == 0 {
-				recordsToInsertIncomplete = append(recordsToInsertIncomplete, book)
+		// books added while this run is going are picked up by the next one
+		runStarted := time.Now()
 
-			} else {
+		s.fetchAll(since)
 
-				recordsToInsertMain = append(recordsToInsertMain, book)
+		if !cfg.DryRun {
+			if cfg.Partial() {
+				slog.Info("partial run, sync time left unchanged")
+			} else if err := saveLastSynced(db, runStarted); err != nil {
+				logging.Fatal("saving the sync time failed", "err", err)
 			}
 		}
+	}
 
-		collection_main.InsertMany(context.Background(), recordsToInsertMain)
+	total := s.total
 
-		collection_incomplete.InsertMany(context.Background(), recordsToInsertIncomplete)
+	var repaired, unrepairable int64
+	if !cfg.SkipRepair {
+		repaired, unrepairable = s.repair(db.Collection(cfg.RepairCollection))
+	}
 
-		log.Printf("%d records inserted\n", len)
+	if cfg.DryRun {
+		slog.Info("dry run finished", "would_insert", total.Inserted, "would_update", total.Updated, "unchanged", total.Unchanged)
+		return
+	}
 
-		count, err := collection_main.CountDocuments(context.Background(), bson.D{})
-		if err != nil {
-			log.Fatal(err.Error())
+	count, err := collection_main.CountDocuments(context.Background(), bson.D{})
+	if err != nil {
+		logging.Fatal("counting the stage collection failed", "err", err)
+	}
+
+	count_incomplete, err := collection_incomplete.CountDocuments(context.Background(), bson.D{})
+	if err != nil {
+		logging.Fatal("counting the incomplete collection failed", "err", err)
+	}
+
+	slog.Info("collections counted", "main", count, "incomplete", count_incomplete)
+
+	seededBooks.WithLabelValues("inserted").Set(float64(total.Inserted))
+	seededBooks.WithLabelValues("updated").Set(float64(total.Updated))
+	seededBooks.WithLabelValues("unchanged").Set(float64(total.Unchanged))
+	seededBooks.WithLabelValues("repaired").Set(float64(repaired))
+	seededBooks.WithLabelValues("unrepairable").Set(float64(unrepairable))
+	collectionBooks.WithLabelValues("main").Set(float64(count))
+	collectionBooks.WithLabelValues("incomplete").Set(float64(count_incomplete))
+	failedPageCount.Set(float64(s.failures))
+
+	if s.failures != 0 {
+		slog.Warn("pages failed, run again with -retry-failed to fetch them", "pages", s.failures)
+	}
+
+	if cfg.NoSwap {
+		slog.Info("stage left in place, the live catalog is unchanged", "stage", cfg.StageCollection)
+		pushMetrics(cfg, started)
+		return
+	}
+
+	if problems := validateStage(db, cfg); len(problems) != 0 {
+		for _, p := range problems {
+			slog.Error("stage validation", "problem", p)
 		}
+		logging.Fatal("stage failed validation, the live catalog is unchanged", "stage", cfg.StageCollection)
+	}
 
-		count_incomplete, err := collection_incomplete.CountDocuments(context.Background(), bson.D{})
-		if err != nil {
-			log.Fatal(err.Error())
+	if err := swap(db, cfg); err != nil {
+		logging.Fatal("swap failed", "err", err)
+	}
+	slog.Info("new catalog is live", "live", cfg.LiveCollection, "previous", cfg.PreviousCollection)
+
+	if err := pruneCatalogMeta(db, cfg.MetaCollection); err != nil {
+		logging.Fatal("clearing the catalog meta failed", "err", err)
+	}
+
+	meta := repos.CatalogMeta{
+		TotalRecords: count + count_incomplete,
+		LastUpdated:  time.Now(),
+		Inserted:     total.Inserted,
+		Updated:      total.Updated,
+		Unchanged:    total.Unchanged,
+		Repaired:     repaired,
+		Unrepairable: unrepairable,
+	}
+	if _, err := db.Collection(cfg.MetaCollection).InsertOne(context.Background(), meta); err != nil {
+		logging.Fatal("saving the catalog meta failed", "err", err)
+	}
+
+	rebuildSuggestions(db)
+
+	pushMetrics(cfg, started)
+}
+
+func rebuildSuggestions(db *mongo.Database) {
+	suggestions, err := repos.NewAudiobookRepo(db).RebuildSuggestions(context.Background())
+	if err != nil {
+		logging.Fatal("rebuilding suggestions failed", "err", err)
+	}
+	slog.Info("suggestions indexed", "count", suggestions)
+}
+
+// maxConsecutiveFailures aborts a run when LibriVox looks down rather than flaky
+const maxConsecutiveFailures = 3
+
+type seeder struct {
+	cfg        config.SeedConfig
+	main       *mongo.Collection
+	incomplete *mongo.Collection
+	failed     *mongo.Collection
+	fetcher    *fetcher
+	total      Counts
+	failures   int
+	// fetched holds the ids of the incomplete books fetched in this run
+	fetched map[string]bool
+}
+
+// fetchAll walks the catalog page by page, failed pages are recorded and skipped
+func (s *seeder) fetchAll(since time.Time) {
+	limit := s.cfg.PageSize
+	offset := s.cfg.Offset
+	pages := 0
+	consecutiveFailures := 0
+
+	for {
+		if s.cfg.MaxPages != 0 && pages == s.cfg.MaxPages {
+			slog.Info("page limit reached", "pages", pages)
+			break
 		}
 
-		log.Printf("%d records in main collection\n", count)
+		response, err := s.fetcher.getPage(s.cfg.BaseURL, limit, offset, since)
+		if errors.Is(err, errEndOfCatalog) {
+			// the whole catalog can't be empty, more likely the URL is wrong or LibriVox is down.
+			// Carrying on would save the sync time and have the next run skip everything before it
+			if offset == 0 && since.IsZero() {
+				logging.Fatal("LibriVox returned no books at all, check LIBRIVOX_URL", "url", s.cfg.BaseURL, "err", err)
+			}
+			slog.Info("reached the end of the catalog")
+			break
+		}
+		pages++
 
-		log.Printf("%d records in incomplete collection\n", count_incomplete)
+		if err != nil {
+			s.pageFailed(FailedPage{Offset: offset, Limit: limit, Since: since}, err)
+			consecutiveFailures++
+			if consecutiveFailures == maxConsecutiveFailures {
+				logging.Fatal("too many pages in a row failed, giving up", "failures", consecutiveFailures)
+			}
+			offset += limit
+			continue
+		}
+		consecutiveFailures = 0
 
-		if cnt == 5 {
-			//break
+		slog.Info("page fetched", "offset", offset, "records", len(response.Books))
+		if err := s.store(response.Books); err != nil {
+			logging.Fatal("storing books failed", "offset", offset, "err", err)
+		}
+
+		if len(response.Books) < limit {
+			break
 		}
-		cnt++
 		offset += limit
 	}
+}
 
-	_, err = db.Collection("meta_data").DeleteMany(context.Background(), bson.D{})
+// retryFailedPages fetches the pages earlier runs recorded as failed
+func (s *seeder) retryFailedPages() {
+	pages, err := failedPages(s.failed)
 	if err != nil {
-		log.Fatal(err.Error())
+		logging.Fatal("reading failed pages failed", "err", err)
 	}
+	slog.Info("retrying failed pages", "pages", len(pages))
 
-	count, err := collection_main.CountDocuments(context.Background(), bson.D{})
+	for _, page := range pages {
+		response, err := s.fetcher.getPage(s.cfg.BaseURL, page.Limit, page.Offset, page.Since)
+		if err != nil && !errors.Is(err, errEndOfCatalog) {
+			s.pageFailed(page, err)
+			continue
+		}
+		if err == nil {
+			if err := s.store(response.Books); err != nil {
+				logging.Fatal("storing books failed", "offset", page.Offset, "err", err)
+			}
+		}
+		if !s.cfg.DryRun {
+			if err := clearFailedPage(s.failed, page); err != nil {
+				logging.Fatal("clearing a failed page failed", "offset", page.Offset, "err", err)
+			}
+		}
+	}
+}
+
+func (s *seeder) pageFailed(page FailedPage, err error) {
+	s.failures++
+	slog.Error("giving up on page", "offset", page.Offset, "err", err)
+	if s.cfg.DryRun {
+		return
+	}
+	page.Error = err.Error()
+	if err := recordFailedPage(s.failed, page); err != nil {
+		logging.Fatal("recording a failed page failed", "offset", page.Offset, "err", err)
+	}
+}
+
+// store splits books by whether they have a duration and writes them, or previews the writes on a dry run
+func (s *seeder) store(books []Audiobook) error {
+	var booksMain []Audiobook
+	var booksIncomplete []Audiobook
+
+	for _, book := range books {
+		if book.TotalTimeSecs == 0 {
+			booksIncomplete = append(booksIncomplete, book)
+			s.fetched[book.ID] = true
+
+		} else {
+
+			booksMain = append(booksMain, book)
+		}
+	}
+
+	write := upsertBooks
+	if s.cfg.DryRun {
+		write = previewBooks
+	}
+
+	counts, err := write(s.main, s.incomplete, booksMain)
 	if err != nil {
-		log.Fatal(err.Error())
+		return err
 	}
+	s.total.Add(counts)
 
-	count_incomplete, err := collection_incomplete.CountDocuments(context.Background(), bson.D{})
+	counts, err = write(s.incomplete, s.main, booksIncomplete)
 	if err != nil {
-		log.Fatal(err.Error())
+		return err
 	}
+	s.total.Add(counts)
 
-	meta := Meta{
-		TotalRecords: count + count_incomplete,
-		LastUpdated:  time.Now(),
+	if s.cfg.DryRun {
+		slog.Info("dry run totals", "would_insert", s.total.Inserted, "would_update", s.total.Updated, "unchanged", s.total.Unchanged)
+	} else {
+		slog.Info("totals", "inserted", s.total.Inserted, "updated", s.total.Updated, "unchanged", s.total.Unchanged)
+	}
+	return nil
+}
+
+// upsertBooks writes books into target keyed by their LibriVox id and removes them from other,
+// so a book that gained or lost its duration moves between the main and incomplete collections
+func upsertBooks(target, other *mongo.Collection, books []Audiobook) (Counts, error) {
+	if len(books) == 0 {
+		return Counts{}, nil
+	}
+
+	models := make([]mongo.WriteModel, 0, len(books))
+	ids := make([]string, 0, len(books))
+	for _, book := range books {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{{Key: "id", Value: book.ID}}).
+			SetUpdate(bson.D{
+				{Key: "$set", Value: book},
+				{Key: "$setOnInsert", Value: bson.D{{Key: "popularity", Value: 0}}},
+			}).
+			SetUpsert(true))
+		ids = append(ids, book.ID)
 	}
-	db.Collection("meta_data").InsertOne(context.Background(), meta)
 
+	res, err := target.BulkWrite(context.Background(), models, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return Counts{}, err
+	}
+
+	if _, err := other.DeleteMany(context.Background(), bson.D{{Key: "id", Value: bson.M{"$in": ids}}}); err != nil {
+		return Counts{}, err
+	}
+
+	return Counts{
+		Inserted:  res.UpsertedCount,
+		Updated:   res.ModifiedCount,
+		Unchanged: res.MatchedCount - res.ModifiedCount,
+	}, nil
 }
 
-func openDB(dsn string) (*mongo.Database, error) {
-	opts := options.Client().ApplyURI(dsn)
-	client, err := mongo.Connect(context.TODO(), opts)
+// previewBooks classifies books the way upsertBooks would without writing anything
+func previewBooks(target, other *mongo.Collection, books []Audiobook) (Counts, error) {
+	if len(books) == 0 {
+		return Counts{}, nil
+	}
+
+	ids := make([]string, 0, len(books))
+	for _, book := range books {
+		ids = append(ids, book.ID)
+	}
+
+	cursor, err := target.Find(context.Background(), bson.D{{Key: "id", Value: bson.M{"$in": ids}}})
 	if err != nil {
-		return nil, err
+		return Counts{}, err
+	}
+	var existing []Audiobook
+	if err := cursor.All(context.Background(), &existing); err != nil {
+		return Counts{}, err
+	}
+	byID := make(map[string]Audiobook, len(existing))
+	for _, book := range existing {
+		byID[book.ID] = book
+	}
+
+	var counts Counts
+	for _, book := range books {
+		current, ok := byID[book.ID]
+		switch {
+		case !ok:
+			counts.Inserted++
+		case sameBook(current, book):
+			counts.Unchanged++
+		default:
+			counts.Updated++
+		}
 	}
-	log.Print("DB connected")
-	return client.Database("audiobooksDB"), nil
+	return counts, nil
 }
 
-func getPage(limit, offset int) (Res, int) {
+// sameBook compares books field by field. A nil and an empty list are the same, Mongo gives back
+// either for a list that was stored empty
+func sameBook(a, b Audiobook) bool {
+	return a.ID == b.ID && a.Title == b.Title && a.Description == b.Description &&
+		a.URLTextSource == b.URLTextSource && a.Language == b.Language &&
+		a.CopyrightYear == b.CopyrightYear && a.NumSections == b.NumSections &&
+		a.URLRSS == b.URLRSS && a.URLZipFile == b.URLZipFile && a.URLProject == b.URLProject &&
+		a.URLLibrivox == b.URLLibrivox && a.URLOther == b.URLOther &&
+		a.TotalTime == b.TotalTime && a.TotalTimeSecs == b.TotalTimeSecs &&
+		slices.Equal(a.Authors, b.Authors) && slices.Equal(a.Sections, b.Sections) &&
+		slices.Equal(a.Genres, b.Genres) && slices.Equal(a.Translators, b.Translators)
+}
 
-	reqString := fmt.Sprintf("https://librivox.org/api/feed/audiobooks?limit=%d&offset=%d&format=json&extended=1", limit, offset)
+// lastSynced returns the start time of the last complete run, zero if there never was one
+func lastSynced(db *mongo.Database) (time.Time, error) {
+	var state SyncState
+	err := db.Collection("sync_state").FindOne(context.Background(), bson.D{{Key: "_id", Value: syncStateID}}).Decode(&state)
+	if err == mongo.ErrNoDocuments {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return state.LastSynced, nil
+}
 
-	fmt.Println(reqString)
+func saveLastSynced(db *mongo.Database, t time.Time) error {
+	_, err := db.Collection("sync_state").ReplaceOne(context.Background(),
+		bson.D{{Key: "_id", Value: syncStateID}},
+		SyncState{ID: syncStateID, LastSynced: t},
+		options.Replace().SetUpsert(true))
+	return err
+}
 
-	res, err := http.Get(reqString)
+// resetLastSynced forgets the last sync so the next run fetches the whole catalog
+func resetLastSynced(db *mongo.Database) error {
+	_, err := db.Collection("sync_state").DeleteOne(context.Background(), bson.D{{Key: "_id", Value: syncStateID}})
+	return err
+}
+
+// stageStarted returns when the stage collection was started, zero if that isn't known
+func stageStarted(db *mongo.Database) (time.Time, error) {
+	var state StageState
+	err := db.Collection("sync_state").FindOne(context.Background(), bson.D{{Key: "_id", Value: stageStateID}}).Decode(&state)
+	if err == mongo.ErrNoDocuments {
+		return time.Time{}, nil
+	}
 	if err != nil {
-		log.Fatal(err)
+		return time.Time{}, err
 	}
+	return state.Started, nil
+}
+
+func saveStageStarted(db *mongo.Database, t time.Time) error {
+	_, err := db.Collection("sync_state").ReplaceOne(context.Background(),
+		bson.D{{Key: "_id", Value: stageStateID}},
+		StageState{ID: stageStateID, Started: t},
+		options.Replace().SetUpsert(true))
+	return err
+}
 
-	responseData, err := io.ReadAll(res.Body)
+func openDB(cfg *config.Config) (*mongo.Database, error) {
+	opts := options.Client().ApplyURI(cfg.DSN)
+	client, err := mongo.Connect(context.TODO(), opts)
 	if err != nil {
-		log.Fatal(err)
-	}
-	//fmt.Println(string(responseData))
-	var response Res
-	json.Unmarshal(responseData, &response)
-	// fmt.Println(response.Books[1])
-	fmt.Println(len(response.Books))
-	return response, len(response.Books)
+		return nil, err
+	}
+	slog.Info("DB connected", "db", cfg.Database)
+	return client.Database(cfg.Database), nil
 }
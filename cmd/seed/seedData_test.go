@@ -0,0 +1,42 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSameBook(t *testing.T) {
+	book := Audiobook{
+		ID:       "1",
+		Title:    "Emma",
+		Authors:  []Author{{ID: "2", FirstName: "Jane", LastName: "Austen"}},
+		Sections: []Section{},
+	}
+
+	tests := []struct {
+		name  string
+		other func(b Audiobook) Audiobook
+		want  bool
+	}{
+		{"same book", func(b Audiobook) Audiobook { return b }, true},
+		{"nil and empty list", func(b Audiobook) Audiobook { b.Sections = nil; return b }, true},
+		{"other title", func(b Audiobook) Audiobook { b.Title = "Persuasion"; return b }, false},
+		{"other duration", func(b Audiobook) Audiobook { b.TotalTimeSecs = 60; return b }, false},
+		{"author added", func(b Audiobook) Audiobook {
+			b.Authors = append(slices.Clone(b.Authors), Author{ID: "3"})
+			return b
+		}, false},
+		{"author renamed", func(b Audiobook) Audiobook {
+			b.Authors = []Author{{ID: "2", FirstName: "J.", LastName: "Austen"}}
+			return b
+		}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sameBook(book, tt.other(book)); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/mayank12gt/free-audiobooks-backend/internal/config"
+	"github.com/mayank12gt/free-audiobooks-backend/internal/logging"
+	"github.com/mayank12gt/free-audiobooks-backend/internal/repos"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// copyCollection replaces the contents of to with a copy of from, from is left untouched
+func copyCollection(db *mongo.Database, from, to string) error {
+	cursor, err := db.Collection(from).Aggregate(context.Background(), bson.A{
+		bson.M{"$match": bson.M{}},
+		bson.M{"$out": to},
+	})
+	if err != nil {
+		return err
+	}
+	return cursor.Close(context.Background())
+}
+
+// renameCollection moves from over to in a single step, dropping whatever to held before
+func renameCollection(db *mongo.Database, from, to string) error {
+	return db.Client().Database("admin").RunCommand(context.Background(), bson.D{
+		{Key: "renameCollection", Value: db.Name() + "." + from},
+		{Key: "to", Value: db.Name() + "." + to},
+		{Key: "dropTarget", Value: true},
+	}).Err()
+}
+
+func collectionExists(db *mongo.Database, name string) (bool, error) {
+	names, err := db.ListCollectionNames(context.Background(), bson.D{{Key: "name", Value: name}})
+	if err != nil {
+		return false, err
+	}
+	return len(names) != 0, nil
+}
+
+// dryRunCollection is the collection a dry run compares the fetched books with: the stage a real
+// run would continue with, or else the live catalog a real run would copy into a fresh stage
+func dryRunCollection(db *mongo.Database, cfg config.SeedConfig) (string, error) {
+	count, err := db.Collection(cfg.StageCollection).EstimatedDocumentCount(context.Background())
+	if err != nil {
+		return "", err
+	}
+	if count != 0 {
+		started, err := stageStarted(db)
+		if err != nil {
+			return "", err
+		}
+		if !started.IsZero() && time.Since(started) < cfg.MaxStageAge {
+			return cfg.StageCollection, nil
+		}
+	}
+	return cfg.LiveCollection, nil
+}
+
+// prepareStage seeds an empty stage collection with the live catalog so an incremental
+// run only has to upsert what changed. A stage left over from a run that wasn't swapped is
+// continued while it is younger than MaxStageAge, an older one or one of unknown age is dropped
+func prepareStage(db *mongo.Database, cfg config.SeedConfig) error {
+	count, err := db.Collection(cfg.StageCollection).EstimatedDocumentCount(context.Background())
+	if err != nil {
+		return err
+	}
+	if count != 0 {
+		started, err := stageStarted(db)
+		if err != nil {
+			return err
+		}
+		if age := time.Since(started); !started.IsZero() && age < cfg.MaxStageAge {
+			slog.Info("continuing with the books already staged", "books", count, "stage", cfg.StageCollection, "age", age.Round(time.Second).String())
+			return nil
+		}
+
+		// the stage may hold books the live catalog never got, they are only fetched again
+		// when the next fetch starts from scratch
+		slog.Warn("discarding the stage left by an earlier run", "books", count, "stage", cfg.StageCollection, "started", started)
+		if err := db.Collection(cfg.StageCollection).Drop(context.Background()); err != nil {
+			return err
+		}
+		if err := resetLastSynced(db); err != nil {
+			return err
+		}
+	}
+
+	if err := saveStageStarted(db, time.Now()); err != nil {
+		return err
+	}
+
+	live, err := collectionExists(db, cfg.LiveCollection)
+	if err != nil || !live {
+		return err
+	}
+
+	slog.Info("copying the live catalog into the stage", "live", cfg.LiveCollection, "stage", cfg.StageCollection)
+	return copyCollection(db, cfg.LiveCollection, cfg.StageCollection)
+}
+
+// validateStage checks the stage collection is fit to go live, returning what is wrong with it
+func validateStage(db *mongo.Database, cfg config.SeedConfig) []string {
+	ctx := context.Background()
+	stage := db.Collection(cfg.StageCollection)
+	var problems []string
+
+	count, err := stage.CountDocuments(ctx, bson.D{})
+	if err != nil {
+		return []string{err.Error()}
+	}
+	if count == 0 {
+		return []string{cfg.StageCollection + " is empty"}
+	}
+
+	invalid, err := stage.CountDocuments(ctx, bson.D{{Key: "$or", Value: bson.A{
+		bson.M{"id": bson.M{"$in": bson.A{nil, ""}}},
+		bson.M{"title": bson.M{"$in": bson.A{nil, ""}}},
+		bson.M{"totaltimesecs": bson.M{"$not": bson.M{"$gt": 0}}},
+	}}})
+	if err != nil {
+		return []string{err.Error()}
+	}
+	if invalid != 0 {
+		problems = append(problems, fmt.Sprintf("%d books are missing an id, a title or a duration", invalid))
+	}
+
+	cursor, err := stage.Aggregate(ctx, bson.A{
+		bson.M{"$group": bson.M{"_id": "$id", "count": bson.M{"$sum": 1}}},
+		bson.M{"$match": bson.M{"count": bson.M{"$gt": 1}}},
+		bson.M{"$count": "duplicates"},
+	})
+	if err != nil {
+		return []string{err.Error()}
+	}
+	var duplicates []struct {
+		Duplicates int `bson:"duplicates"`
+	}
+	if err := cursor.All(ctx, &duplicates); err != nil {
+		return []string{err.Error()}
+	}
+	if len(duplicates) != 0 {
+		problems = append(problems, fmt.Sprintf("%d ids appear more than once", duplicates[0].Duplicates))
+	}
+
+	live, err := db.Collection(cfg.LiveCollection).CountDocuments(ctx, bson.D{})
+	if err != nil {
+		return []string{err.Error()}
+	}
+	if float64(count) < cfg.MinRatio*float64(live) {
+		problems = append(problems, fmt.Sprintf("%s has %d books, less than %.0f%% of the %d live ones", cfg.StageCollection, count, cfg.MinRatio*100, live))
+	}
+
+	return problems
+}
+
+// buildIndexes creates the indexes the API relies on, so the collection is fast the moment it goes live
+func buildIndexes(db *mongo.Database, collection string) error {
+	_, err := repos.ReconcileIndexes(context.Background(), db.Collection(collection), repos.AudiobookIndexes(), false)
+	return err
+}
+
+// swap makes the stage collection live. The live catalog is copied aside for rollback first,
+// then the stage is renamed over it, so readers see either the old or the new catalog and never a mix
+func swap(db *mongo.Database, cfg config.SeedConfig) error {
+	if err := buildIndexes(db, cfg.StageCollection); err != nil {
+		return err
+	}
+
+	// views are counted apart from the books, popularity is brought up to date as the books go live
+	if err := repos.ApplyViews(context.Background(), db, cfg.StageCollection); err != nil {
+		return err
+	}
+
+	live, err := collectionExists(db, cfg.LiveCollection)
+	if err != nil {
+		return err
+	}
+	if live {
+		if err := copyCollection(db, cfg.LiveCollection, cfg.PreviousCollection); err != nil {
+			return err
+		}
+	}
+
+	return renameCollection(db, cfg.StageCollection, cfg.LiveCollection)
+}
+
+// rollback puts the previous catalog back in place, keeping the one it replaces for inspection
+func rollback(cfg config.SeedConfig, db *mongo.Database) {
+	previous, err := collectionExists(db, cfg.PreviousCollection)
+	if err != nil {
+		logging.Fatal("rollback failed", "err", err)
+	}
+	if !previous {
+		logging.Fatal("there is no previous catalog to roll back to", "previous", cfg.PreviousCollection)
+	}
+
+	if err := buildIndexes(db, cfg.PreviousCollection); err != nil {
+		logging.Fatal("building indexes failed", "collection", cfg.PreviousCollection, "err", err)
+	}
+
+	if err := repos.ApplyViews(context.Background(), db, cfg.PreviousCollection); err != nil {
+		logging.Fatal("updating popularity failed", "collection", cfg.PreviousCollection, "err", err)
+	}
+
+	if err := copyCollection(db, cfg.LiveCollection, cfg.RejectedCollection); err != nil {
+		logging.Fatal("keeping the rolled back catalog failed", "err", err)
+	}
+
+	if err := renameCollection(db, cfg.PreviousCollection, cfg.LiveCollection); err != nil {
+		logging.Fatal("restoring the previous catalog failed", "err", err)
+	}
+	slog.Info("previous catalog restored", "live", cfg.LiveCollection, "previous", cfg.PreviousCollection, "rejected", cfg.RejectedCollection)
+
+	// the sync time is the one of the rejected run, keeping it would have the next run skip
+	// every change the restored catalog is missing
+	if err := resetLastSynced(db); err != nil {
+		logging.Fatal("resetting the sync time failed", "err", err)
+	}
+
+	restored, err := restoreCatalogMeta(db, cfg.MetaCollection)
+	if err != nil {
+		logging.Fatal("restoring the catalog meta failed", "err", err)
+	}
+	if restored {
+		slog.Info("catalog meta of the previous run restored")
+	} else {
+		slog.Warn("no catalog meta of the previous run, the catalog meta is cleared until the next run")
+	}
+
+	rebuildSuggestions(db)
+}
+
+// pruneCatalogMeta drops every catalog meta but the newest, which describes the catalog that is
+// about to become the previous one and is what a rollback restores
+func pruneCatalogMeta(db *mongo.Database, collection string) error {
+	var latest repos.CatalogMeta
+	err := db.Collection(collection).FindOne(context.Background(), bson.D{},
+		options.FindOne().SetSort(bson.D{{Key: "last_updated", Value: -1}})).Decode(&latest)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = db.Collection(collection).DeleteMany(context.Background(),
+		bson.D{{Key: "last_updated", Value: bson.D{{Key: "$lt", Value: latest.LastUpdated}}}})
+	return err
+}
+
+// restoreCatalogMeta drops the catalog meta of the rolled back run so the one of the run before
+// it is the newest again. It reports false when there was none to restore
+func restoreCatalogMeta(db *mongo.Database, collection string) (bool, error) {
+	err := db.Collection(collection).FindOneAndDelete(context.Background(), bson.D{},
+		options.FindOneAndDelete().SetSort(bson.D{{Key: "last_updated", Value: -1}})).Err()
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return false, err
+	}
+	count, err := db.Collection(collection).CountDocuments(context.Background(), bson.D{})
+	return count != 0, err
+}
@@ -0,0 +1,494 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/mayank12gt/free-audiobooks-backend/internal/logging"
+)
+
+// programs a setting can belong to
+const (
+	API     = "api"
+	Seed    = "seed"
+	Migrate = "migrate"
+)
+
+// Config holds every setting of the api, the seeder and the migrate command
+type Config struct {
+	DSN       string
+	Database  string
+	LogLevel  string
+	LogFormat string
+
+	Port              string
+	Store             string
+	MemoryData        string
+	CORSOrigins       []string
+	DefaultPageSize   int
+	MaxPageSize       int
+	IndexCheck        string
+	DBConnectAttempts int
+	ReadyTimeout      time.Duration
+	MaxCatalogAge     time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	ShutdownTimeout   time.Duration
+	QueryTimeout      time.Duration
+	SearchTimeout     time.Duration
+	TraceExporter     string
+	TraceEndpoint     string
+	TraceSampleRatio  float64
+	RateLimit         float64
+	RateBurst         int
+	SearchRateLimit   float64
+	SearchRateBurst   int
+	APIKeys           []string
+	TrustProxy        bool
+	MaxSearchLength   int
+	MaxGenres         int
+	ViewFlushInterval time.Duration
+
+	Seed SeedConfig
+}
+
+// SeedConfig holds the settings only the seeder reads
+type SeedConfig struct {
+	BaseURL              string
+	PageSize             int
+	Offset               int
+	MaxPages             int
+	StageCollection      string
+	IncompleteCollection string
+	MetaCollection       string
+	DryRun               bool
+	FailedCollection     string
+	RetryFailed          bool
+	MaxRetries           int
+	RetryDelay           time.Duration
+	RequestsPerSecond    float64
+	RequestTimeout       time.Duration
+	SkipRepair           bool
+	RepairRSS            bool
+	RepairCollection     string
+	LiveCollection       string
+	PreviousCollection   string
+	RejectedCollection   string
+	NoSwap               bool
+	MinRatio             float64
+	MaxStageAge          time.Duration
+	PruneIndexes         bool
+	PushgatewayURL       string
+}
+
+// Partial reports whether the run covers only part of the catalog, such runs don't move the sync time
+func (s SeedConfig) Partial() bool {
+	return s.Offset != 0 || s.MaxPages != 0
+}
+
+// setting ties a Config field to its env and config file key and to its flag, flag is empty
+// for settings that can't be given on the command line
+type setting struct {
+	key      string
+	flag     string
+	usage    string
+	value    interface{}
+	programs []string
+}
+
+func (s setting) appliesTo(program string) bool {
+	for _, p := range s.programs {
+		if p == program {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	all      = []string{API, Seed, Migrate}
+	apiOnly  = []string{API}
+	seedOnly = []string{Seed}
+)
+
+func defaults() *Config {
+	return &Config{
+		Database:          "audiobooksDB",
+		LogLevel:          "info",
+		LogFormat:         "text",
+		Port:              "8080",
+		Store:             "mongo",
+		CORSOrigins:       []string{"*"},
+		DefaultPageSize:   20,
+		MaxPageSize:       50,
+		IndexCheck:        "warn",
+		DBConnectAttempts: 5,
+		ReadyTimeout:      2 * time.Second,
+		ReadTimeout:       15 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+		ShutdownTimeout:   30 * time.Second,
+		QueryTimeout:      5 * time.Second,
+		SearchTimeout:     10 * time.Second,
+		TraceExporter:     "none",
+		TraceEndpoint:     "http://localhost:4318",
+		TraceSampleRatio:  1,
+		RateLimit:         10,
+		RateBurst:         20,
+		SearchRateLimit:   1,
+		SearchRateBurst:   5,
+		MaxSearchLength:   100,
+		MaxGenres:         10,
+		ViewFlushInterval: 10 * time.Second,
+		Seed: SeedConfig{
+			BaseURL:              "https://librivox.org/api/feed/audiobooks",
+			PageSize:             500,
+			StageCollection:      "seed_stage",
+			IncompleteCollection: "incomplete",
+			MetaCollection:       "meta_data",
+			FailedCollection:     "failed_pages",
+			MaxRetries:           5,
+			RetryDelay:           time.Second,
+			RequestsPerSecond:    1,
+			RequestTimeout:       time.Minute,
+			RepairRSS:            true,
+			RepairCollection:     "repair_report",
+			LiveCollection:       "audiobooks",
+			PreviousCollection:   "audiobooks_prev",
+			RejectedCollection:   "audiobooks_rejected",
+			MinRatio:             0.9,
+			MaxStageAge:          24 * time.Hour,
+		},
+	}
+}
+
+func (cfg *Config) settings() []setting {
+	s := &cfg.Seed
+	return []setting{
+		{"DSN", "dsn", "MongoDB connection string", &cfg.DSN, all},
+		{"DB_NAME", "db", "MongoDB database name", &cfg.Database, all},
+		{"LOG_LEVEL", "log-level", "lowest level logged: debug, info, warn or error", &cfg.LogLevel, all},
+		{"LOG_FORMAT", "log-format", "log line format, text or json", &cfg.LogFormat, all},
+
+		{"PORT", "port", "port the API listens on", &cfg.Port, apiOnly},
+		{"STORE", "store", "catalog backend, mongo or memory", &cfg.Store, apiOnly},
+		{"MEMORY_DATA", "memory-data", "JSON file the memory store is loaded from", &cfg.MemoryData, apiOnly},
+		{"CORS_ORIGINS", "cors-origins", "comma separated origins allowed to call the API", &cfg.CORSOrigins, apiOnly},
+		{"DEFAULT_PAGE_SIZE", "", "page size when a request doesn't give one", &cfg.DefaultPageSize, apiOnly},
+		{"MAX_PAGE_SIZE", "", "largest page size a request may ask for", &cfg.MaxPageSize, apiOnly},
+		{"INDEX_CHECK", "index-check", "what a missing index does at startup: fail, warn or off", &cfg.IndexCheck, apiOnly},
+		{"DB_CONNECT_ATTEMPTS", "", "MongoDB pings tried at startup before giving up", &cfg.DBConnectAttempts, apiOnly},
+		{"READY_TIMEOUT", "", "timeout of each readiness check", &cfg.ReadyTimeout, apiOnly},
+		{"MAX_CATALOG_AGE", "", "catalog age after which the instance isn't ready, 0 never", &cfg.MaxCatalogAge, apiOnly},
+		{"HTTP_READ_TIMEOUT", "", "timeout for reading a request", &cfg.ReadTimeout, apiOnly},
+		{"HTTP_WRITE_TIMEOUT", "", "timeout for writing a response", &cfg.WriteTimeout, apiOnly},
+		{"HTTP_IDLE_TIMEOUT", "", "how long idle keep-alive connections are kept", &cfg.IdleTimeout, apiOnly},
+		{"SHUTDOWN_TIMEOUT", "", "how long in-flight requests are drained on shutdown", &cfg.ShutdownTimeout, apiOnly},
+		{"DB_QUERY_TIMEOUT", "", "deadline and maxTimeMS of a single database lookup", &cfg.QueryTimeout, apiOnly},
+		{"DB_SEARCH_TIMEOUT", "", "deadline and maxTimeMS of text searches, facets and other aggregations", &cfg.SearchTimeout, apiOnly},
+		{"TRACE_EXPORTER", "trace-exporter", "where spans go: none, stdout or otlp", &cfg.TraceExporter, apiOnly},
+		{"TRACE_ENDPOINT", "trace-endpoint", "URL of the collector's OTLP/HTTP receiver", &cfg.TraceEndpoint, apiOnly},
+		{"TRACE_SAMPLE_RATIO", "trace-sample-ratio", "share of new traces that are sampled, between 0 and 1", &cfg.TraceSampleRatio, apiOnly},
+		{"RATE_LIMIT", "rate-limit", "requests per second each client may make, 0 for no limit", &cfg.RateLimit, apiOnly},
+		{"RATE_BURST", "", "requests a client may make at once before RATE_LIMIT applies", &cfg.RateBurst, apiOnly},
+		{"SEARCH_RATE_LIMIT", "search-rate-limit", "searches, facets, similar books and stats per second each client may make, 0 counts them against RATE_LIMIT", &cfg.SearchRateLimit, apiOnly},
+		{"SEARCH_RATE_BURST", "", "searches a client may make at once before SEARCH_RATE_LIMIT applies", &cfg.SearchRateBurst, apiOnly},
+		{"API_KEYS", "", "comma separated keys that get a budget of their own when sent as X-API-Key", &cfg.APIKeys, apiOnly},
+		{"TRUST_PROXY", "trust-proxy", "take the client IP from X-Forwarded-For set by a proxy on a private network", &cfg.TrustProxy, apiOnly},
+		{"MAX_SEARCH_LENGTH", "", "longest search string a request may give, in characters", &cfg.MaxSearchLength, apiOnly},
+		{"MAX_GENRES", "", "most genre ids a request may filter by", &cfg.MaxGenres, apiOnly},
+		{"VIEW_FLUSH_INTERVAL", "", "how often the detail views counted towards popularity are written", &cfg.ViewFlushInterval, apiOnly},
+
+		{"LIBRIVOX_URL", "base-url", "LibriVox audiobooks feed URL", &s.BaseURL, seedOnly},
+		{"SEED_PAGE_SIZE", "page-size", "books requested per page", &s.PageSize, seedOnly},
+		{"SEED_OFFSET", "offset", "offset of the first page", &s.Offset, seedOnly},
+		{"SEED_MAX_PAGES", "max-pages", "stop after this many pages, 0 fetches everything", &s.MaxPages, seedOnly},
+		{"SEED_STAGE_COLLECTION", "stage-collection", "collection complete books are written to", &s.StageCollection, seedOnly},
+		{"SEED_INCOMPLETE_COLLECTION", "incomplete-collection", "collection books without a duration are written to", &s.IncompleteCollection, seedOnly},
+		{"SEED_META_COLLECTION", "meta-collection", "collection the run summary is written to", &s.MetaCollection, seedOnly},
+		{"", "dry-run", "fetch and compare but only report what would be written", &s.DryRun, seedOnly},
+		{"SEED_FAILED_COLLECTION", "failed-collection", "collection pages that could not be fetched are recorded in", &s.FailedCollection, seedOnly},
+		{"", "retry-failed", "only retry the pages recorded by earlier runs", &s.RetryFailed, seedOnly},
+		{"SEED_MAX_RETRIES", "max-retries", "retries for each page before it is recorded as failed", &s.MaxRetries, seedOnly},
+		{"SEED_RETRY_DELAY", "retry-delay", "delay before the first retry, doubled on every further retry", &s.RetryDelay, seedOnly},
+		{"SEED_RATE", "rate", "maximum requests per second to LibriVox, 0 for no limit", &s.RequestsPerSecond, seedOnly},
+		{"SEED_REQUEST_TIMEOUT", "request-timeout", "timeout for a single page request", &s.RequestTimeout, seedOnly},
+		{"", "skip-repair", "don't try to derive durations for books in the incomplete collection", &s.SkipRepair, seedOnly},
+		{"SEED_REPAIR_RSS", "repair-rss", "fall back to the RSS feed when section playtimes can't give a duration", &s.RepairRSS, seedOnly},
+		{"SEED_REPAIR_COLLECTION", "repair-collection", "collection the reasons for unrepairable books are written to", &s.RepairCollection, seedOnly},
+		{"SEED_LIVE_COLLECTION", "live-collection", "collection the API serves", &s.LiveCollection, seedOnly},
+		{"SEED_PREVIOUS_COLLECTION", "previous-collection", "collection the replaced catalog is kept in for rollback", &s.PreviousCollection, seedOnly},
+		{"SEED_REJECTED_COLLECTION", "rejected-collection", "collection a rolled back catalog is kept in", &s.RejectedCollection, seedOnly},
+		{"", "no-swap", "fill the stage collection but don't make it live", &s.NoSwap, seedOnly},
+		{"SEED_MIN_RATIO", "min-ratio", "refuse to swap when the stage holds fewer books than this share of the live catalog", &s.MinRatio, seedOnly},
+		{"SEED_MAX_STAGE_AGE", "max-stage-age", "age after which a stage left by an earlier run is discarded instead of continued, 0 always discards it", &s.MaxStageAge, seedOnly},
+		{"", "prune", "indexes: also drop indexes that aren't in the spec", &s.PruneIndexes, seedOnly},
+		{"SEED_PUSHGATEWAY_URL", "pushgateway", "Prometheus Pushgateway the run metrics are pushed to, empty to skip", &s.PushgatewayURL, seedOnly},
+	}
+}
+
+// Load builds the configuration of program from args. Each setting is taken from its flag, then
+// the environment, then the file named by -config or CONFIG_FILE and last from the defaults.
+// A .env file in the working directory is loaded into the environment first. flags may already
+// hold flags of the caller's own, nil gives a new set
+func Load(program string, flags *flag.FlagSet, args []string) (*Config, error) {
+	if err := godotenv.Load(); err != nil {
+		slog.Debug("no env file found")
+	}
+
+	cfg := defaults()
+	settings := cfg.settings()
+
+	if flags == nil {
+		flags = flag.NewFlagSet(program, flag.ContinueOnError)
+	}
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "file of KEY=value settings read after the environment")
+	for _, s := range settings {
+		if s.flag != "" && s.appliesTo(program) {
+			flags.Var(&flagValue{s.value}, s.flag, s.usage+keyHint(s.key))
+		}
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		return nil, err
+	}
+	if flags.NArg() != 0 {
+		return nil, fmt.Errorf("unexpected arguments %v", flags.Args())
+	}
+
+	fromFlags := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		fromFlags[f.Name] = true
+	})
+
+	file := map[string]string{}
+	if *configFile != "" {
+		var err error
+		file, err = godotenv.Read(*configFile)
+		if err != nil {
+			return nil, fmt.Errorf("config file: %w", err)
+		}
+	}
+
+	for _, s := range settings {
+		if s.key == "" || !s.appliesTo(program) || fromFlags[s.flag] {
+			continue
+		}
+		raw, source := os.Getenv(s.key), "env"
+		if raw == "" {
+			raw, source = file[s.key], *configFile
+		}
+		if raw == "" {
+			continue
+		}
+		if err := set(s.value, raw); err != nil {
+			return nil, fmt.Errorf("%s from %s: %w", s.key, source, err)
+		}
+	}
+
+	if err := cfg.validate(program); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func keyHint(key string) string {
+	if key == "" {
+		return ""
+	}
+	return " ($" + key + ")"
+}
+
+// validate reports every setting of program that can't work, one per line
+func (cfg *Config) validate(program string) error {
+	var problems []string
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	needsDB := program != API || cfg.Store == "mongo"
+	if needsDB && cfg.DSN == "" {
+		problem("DSN is not set, give the MongoDB connection string in the environment, the config file or with -dsn")
+	}
+	if needsDB && cfg.Database == "" {
+		problem("DB_NAME must not be empty")
+	}
+
+	if !logging.ValidLevel(cfg.LogLevel) {
+		problem("LOG_LEVEL must be debug, info, warn or error, got %q", cfg.LogLevel)
+	}
+	if cfg.LogFormat != "text" && cfg.LogFormat != "json" {
+		problem("LOG_FORMAT must be text or json, got %q", cfg.LogFormat)
+	}
+
+	if program == API {
+		if _, err := strconv.ParseUint(cfg.Port, 10, 16); err != nil {
+			problem("PORT must be a port number, got %q", cfg.Port)
+		}
+		if cfg.Store != "mongo" && cfg.Store != "memory" {
+			problem("STORE must be mongo or memory, got %q", cfg.Store)
+		}
+		if len(cfg.CORSOrigins) == 0 {
+			problem("CORS_ORIGINS must list at least one origin")
+		}
+		if cfg.MaxPageSize < 1 {
+			problem("MAX_PAGE_SIZE must be at least 1")
+		}
+		if cfg.DefaultPageSize < 1 || cfg.DefaultPageSize > cfg.MaxPageSize {
+			problem("DEFAULT_PAGE_SIZE must be between 1 and MAX_PAGE_SIZE (%d)", cfg.MaxPageSize)
+		}
+		if cfg.IndexCheck != "fail" && cfg.IndexCheck != "warn" && cfg.IndexCheck != "off" {
+			problem("INDEX_CHECK must be fail, warn or off, got %q", cfg.IndexCheck)
+		}
+		if cfg.DBConnectAttempts < 1 {
+			problem("DB_CONNECT_ATTEMPTS must be at least 1")
+		}
+		for key, d := range map[string]time.Duration{
+			"READY_TIMEOUT":       cfg.ReadyTimeout,
+			"HTTP_READ_TIMEOUT":   cfg.ReadTimeout,
+			"HTTP_WRITE_TIMEOUT":  cfg.WriteTimeout,
+			"HTTP_IDLE_TIMEOUT":   cfg.IdleTimeout,
+			"SHUTDOWN_TIMEOUT":    cfg.ShutdownTimeout,
+			"DB_QUERY_TIMEOUT":    cfg.QueryTimeout,
+			"DB_SEARCH_TIMEOUT":   cfg.SearchTimeout,
+			"VIEW_FLUSH_INTERVAL": cfg.ViewFlushInterval,
+		} {
+			if d <= 0 {
+				problem("%s must be positive", key)
+			}
+		}
+		if cfg.MaxCatalogAge < 0 {
+			problem("MAX_CATALOG_AGE must not be negative")
+		}
+		if cfg.TraceExporter != "none" && cfg.TraceExporter != "stdout" && cfg.TraceExporter != "otlp" {
+			problem("TRACE_EXPORTER must be none, stdout or otlp, got %q", cfg.TraceExporter)
+		}
+		if cfg.TraceSampleRatio < 0 || cfg.TraceSampleRatio > 1 {
+			problem("TRACE_SAMPLE_RATIO must be between 0 and 1")
+		}
+		if cfg.RateLimit < 0 || cfg.SearchRateLimit < 0 {
+			problem("RATE_LIMIT and SEARCH_RATE_LIMIT must not be negative")
+		}
+		if cfg.RateLimit > 0 && cfg.RateBurst < 1 || cfg.SearchRateLimit > 0 && cfg.SearchRateBurst < 1 {
+			problem("RATE_BURST and SEARCH_RATE_BURST must be at least 1")
+		}
+		if cfg.MaxSearchLength < 1 || cfg.MaxGenres < 1 {
+			problem("MAX_SEARCH_LENGTH and MAX_GENRES must be at least 1")
+		}
+	}
+
+	if program == Seed {
+		s := cfg.Seed
+		if s.BaseURL == "" {
+			problem("LIBRIVOX_URL must not be empty")
+		}
+		if s.PageSize < 1 {
+			problem("SEED_PAGE_SIZE must be at least 1")
+		}
+		if s.Offset < 0 || s.MaxPages < 0 || s.MaxRetries < 0 {
+			problem("SEED_OFFSET, SEED_MAX_PAGES and SEED_MAX_RETRIES must not be negative")
+		}
+		if s.RequestsPerSecond < 0 {
+			problem("SEED_RATE must not be negative")
+		}
+		if s.RetryDelay <= 0 || s.RequestTimeout <= 0 {
+			problem("SEED_RETRY_DELAY and SEED_REQUEST_TIMEOUT must be positive")
+		}
+		if s.MinRatio < 0 || s.MinRatio > 1 {
+			problem("SEED_MIN_RATIO must be between 0 and 1")
+		}
+		if s.MaxStageAge < 0 {
+			problem("SEED_MAX_STAGE_AGE must not be negative")
+		}
+	}
+
+	if len(problems) != 0 {
+		return errors.New("invalid configuration:\n  " + strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// flagValue lets any setting be registered as a flag
+type flagValue struct {
+	value interface{}
+}
+
+func (f *flagValue) String() string {
+	if f == nil || f.value == nil {
+		return ""
+	}
+	switch v := f.value.(type) {
+	case *string:
+		return *v
+	case *[]string:
+		return strings.Join(*v, ",")
+	case *bool:
+		return strconv.FormatBool(*v)
+	case *int:
+		return strconv.Itoa(*v)
+	case *float64:
+		return strconv.FormatFloat(*v, 'g', -1, 64)
+	case *time.Duration:
+		return v.String()
+	}
+	return ""
+}
+
+func (f *flagValue) Set(raw string) error {
+	return set(f.value, raw)
+}
+
+// IsBoolFlag lets boolean settings be given as a bare -flag
+func (f *flagValue) IsBoolFlag() bool {
+	_, ok := f.value.(*bool)
+	return ok
+}
+
+func set(value interface{}, raw string) error {
+	switch v := value.(type) {
+	case *string:
+		*v = raw
+	case *[]string:
+		*v = nil
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*v = append(*v, item)
+			}
+		}
+	case *bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", raw)
+		}
+		*v = b
+	case *int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", raw)
+		}
+		*v = n
+	case *float64:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", raw)
+		}
+		*v = n
+	case *time.Duration:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration like 30s or 5m", raw)
+		}
+		*v = d
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadPrecedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "api.env")
+	if err := os.WriteFile(file, []byte("PORT=3000\nMAX_PAGE_SIZE=80\nDEFAULT_PAGE_SIZE=30\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", file)
+	t.Setenv("MAX_PAGE_SIZE", "60")
+
+	cfg, err := Load(API, nil, []string{"-store", "memory", "-port", "4000"})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	// the flag beats the file, the environment beats the file, the file beats the defaults
+	if cfg.Port != "4000" || cfg.MaxPageSize != 60 || cfg.DefaultPageSize != 30 {
+		t.Errorf("port %s, max page size %d, default page size %d", cfg.Port, cfg.MaxPageSize, cfg.DefaultPageSize)
+	}
+}
+
+func TestLoadReportsEveryProblem(t *testing.T) {
+	_, err := Load(API, nil, []string{"-store", "files", "-port", "http"})
+	if err == nil {
+		t.Fatal("no error")
+	}
+	for _, key := range []string{"PORT", "STORE"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("%q doesn't mention %s", err, key)
+		}
+	}
+}
+
+func TestLoadMongoNeedsDSN(t *testing.T) {
+	t.Setenv("DSN", "")
+	if _, err := Load(Seed, nil, nil); err == nil || !strings.Contains(err.Error(), "DSN") {
+		t.Errorf("err = %v, want a missing DSN", err)
+	}
+}
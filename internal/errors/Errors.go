@@ -1,32 +1,81 @@
 package Error
 
 import (
-	"log"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
 )
 
+// Kind classifies an error so the HTTP layer can answer with the right status
+type Kind string
+
+const (
+	KindValidation  Kind = "validation"
+	KindNotFound    Kind = "not_found"
+	KindUnavailable Kind = "unavailable"
+	KindTimeout     Kind = "timeout"
+	KindInternal    Kind = "internal"
+	KindRateLimited Kind = "rate_limited"
+)
+
+var kindStatus = map[Kind]int{
+	KindValidation:  http.StatusBadRequest,
+	KindNotFound:    http.StatusNotFound,
+	KindUnavailable: http.StatusServiceUnavailable,
+	KindTimeout:     http.StatusGatewayTimeout,
+	KindInternal:    http.StatusInternalServerError,
+	KindRateLimited: http.StatusTooManyRequests,
+}
+
+var kindMessage = map[Kind]string{
+	KindValidation:  "invalid request",
+	KindNotFound:    "not found",
+	KindUnavailable: "service unavailable",
+	KindTimeout:     "the request took too long",
+	KindInternal:    "internal server error",
+	KindRateLimited: "too many requests",
+}
+
+// Err is an error of a known Kind with a client facing message and, for validation
+// errors, one message per offending field in E
 type Err struct {
 	status_code int               `json:"-"`
+	kind        Kind              `json:"-"`
+	message     string            `json:"-"`
 	E           map[string]string `json:"errors"`
 }
 
 func (e Err) Error() string {
-	var combinedErr string
-	for _, value := range e.E {
-		combinedErr = combinedErr + value
+	if len(e.E) == 0 {
+		return e.Message()
+	}
+	keys := make([]string, 0, len(e.E))
+	for key := range e.E {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fields := make([]string, 0, len(keys))
+	for _, key := range keys {
+		fields = append(fields, key+": "+e.E[key])
 	}
-	return combinedErr
+	return e.Message() + ": " + strings.Join(fields, ", ")
 }
 
+// Set adds a message for a request field, messages for the same field are joined
 func (e *Err) Set(key string, err string) *Err {
 	if e == nil {
 		e = NewError()
 	}
-	log.Print(e.Error())
-	e.E[key] = e.E[key] + " " + err
-	log.Print(e.Error())
+	if e.E[key] != "" {
+		err = e.E[key] + "; " + err
+	}
+	e.E[key] = err
 	return e
 }
 
+// SetCode overrides the status the kind would give
 func (e *Err) SetCode(code int) *Err {
 	if e == nil {
 		e = NewError()
@@ -35,9 +84,72 @@ func (e *Err) SetCode(code int) *Err {
 	return e
 }
 
+// Code is the status code set with SetCode, 0 when none was set
+func (e *Err) Code() int {
+	if e == nil {
+		return 0
+	}
+	return e.status_code
+}
+
+func (e *Err) Kind() Kind {
+	if e == nil || e.kind == "" {
+		return KindValidation
+	}
+	return e.kind
+}
+
+func (e *Err) Message() string {
+	if e.message != "" {
+		return e.message
+	}
+	return kindMessage[e.Kind()]
+}
+
+// Status is the HTTP status to answer with, the code set with SetCode or else the one of the kind
+func (e *Err) Status() int {
+	if e.Code() != 0 {
+		return e.Code()
+	}
+	return kindStatus[e.Kind()]
+}
+
+// NewError starts a validation error, fields are added with Set
 func NewError() *Err {
-	//log.Print(msg)
+	return newErr(KindValidation, "")
+}
+
+func NotFound(message string) *Err {
+	return newErr(KindNotFound, message)
+}
+
+func Unavailable(message string) *Err {
+	return newErr(KindUnavailable, message)
+}
+
+func Timeout(message string) *Err {
+	return newErr(KindTimeout, message)
+}
+
+func Internal(message string) *Err {
+	return newErr(KindInternal, message)
+}
+
+func RateLimited(message string) *Err {
+	return newErr(KindRateLimited, message)
+}
+
+func newErr(kind Kind, message string) *Err {
 	return &Err{
-		E: make(map[string]string),
+		kind:    kind,
+		message: message,
+		E:       make(map[string]string),
 	}
 }
+
+// As finds the first *Err in err's chain
+func As(err error) (*Err, bool) {
+	var e *Err
+	ok := errors.As(err, &e)
+	return e, ok
+}
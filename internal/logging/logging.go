@@ -0,0 +1,74 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+var levels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// ValidLevel reports whether level is one New understands
+func ValidLevel(level string) bool {
+	_, ok := levels[strings.ToLower(level)]
+	return ok
+}
+
+// New builds the logger the api, the seeder and the migrate command share. format is text or json,
+// level the lowest level written. The logger also becomes the default so the log package writes through it
+func New(w io.Writer, level, format string) *slog.Logger {
+	options := &slog.HandlerOptions{Level: levels[strings.ToLower(level)]}
+
+	var handler slog.Handler
+	if format == "json" {
+		handler = slog.NewJSONHandler(w, options)
+	} else {
+		handler = slog.NewTextHandler(w, options)
+	}
+
+	logger := slog.New(handler)
+	slog.SetDefault(logger)
+	return logger
+}
+
+type loggerKey struct{}
+
+type requestIDKey struct{}
+
+// WithRequestID stores the request ID in ctx and tags every line logged through FromContext with it
+func WithRequestID(ctx context.Context, id string) context.Context {
+	ctx = context.WithValue(ctx, requestIDKey{}, id)
+	return WithLogger(ctx, FromContext(ctx).With("request_id", id))
+}
+
+// RequestID is the ID stored with WithRequestID, empty outside a request
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// WithLogger stores logger in ctx for FromContext
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext is the logger stored in ctx, the default one when there is none
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// Fatal logs msg at error level and exits, for errors a command can't go on after
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "audiobooks"
+
+// HTTP metrics, route is the registered path like /audiobooks/:id so ids never become labels
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests answered, by route, method and status.",
+	}, []string{"route", "method", "status"})
+
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to answer HTTP requests, by route, method and status.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"route", "method", "status"})
+)
+
+// database metrics, method is the repo method and op the kind of call it made, like count or find
+var (
+	DBDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_operation_duration_seconds",
+		Help:      "Time taken by MongoDB calls, by repo method and operation.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"method", "op"})
+
+	DBErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "db_operation_errors_total",
+		Help:      "MongoDB calls that failed, by repo method and operation.",
+	}, []string{"method", "op"})
+)
+
+// ObserveDB starts timing one database call, the returned func records it with the call's error
+func ObserveDB(method, op string) func(err error) {
+	start := time.Now()
+	return func(err error) {
+		DBDuration.WithLabelValues(method, op).Observe(time.Since(start).Seconds())
+		if err != nil {
+			DBErrors.WithLabelValues(method, op).Inc()
+		}
+	}
+}
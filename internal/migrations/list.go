@@ -0,0 +1,143 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mayank12gt/free-audiobooks-backend/internal/repos"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// all lists every migration in version order, new ones are appended with the next version
+var all = []Migration{
+	{
+		Version:     1,
+		Description: "create the indexes in the index spec",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			for collection, models := range repos.IndexSpec() {
+				if _, err := repos.ReconcileIndexes(ctx, db.Collection(collection), models, false); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			for collection, models := range repos.IndexSpec() {
+				report, err := repos.CheckIndexes(ctx, db.Collection(collection), models)
+				if err != nil {
+					return err
+				}
+				missing := make(map[string]bool)
+				for _, name := range report.Missing {
+					missing[name] = true
+				}
+				for _, model := range models {
+					name := *model.Options.Name
+					if missing[name] {
+						continue
+					}
+					if _, err := db.Collection(collection).Indexes().DropOne(ctx, name); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version:     2,
+		Description: "convert totaltimesecs stored as a string to a number",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("audiobooks").UpdateMany(ctx,
+				bson.D{{Key: "totaltimesecs", Value: bson.M{"$type": "string"}}},
+				bson.A{bson.M{"$set": bson.M{"totaltimesecs": bson.M{"$convert": bson.M{
+					"input": "$totaltimesecs", "to": "int", "onError": 0, "onNull": 0,
+				}}}}})
+			return err
+		},
+	},
+	{
+		Version:     3,
+		Description: "backfill popularity on books that predate it",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("audiobooks").UpdateMany(ctx,
+				bson.D{{Key: "popularity", Value: bson.M{"$exists": false}}},
+				bson.D{{Key: "$set", Value: bson.D{{Key: "popularity", Value: 0}}}})
+			return err
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("audiobooks").UpdateMany(ctx,
+				bson.D{{Key: "popularity", Value: 0}},
+				bson.D{{Key: "$unset", Value: bson.D{{Key: "popularity", Value: ""}}}})
+			return err
+		},
+	},
+	{
+		Version:     4,
+		Description: "build the suggestions collection",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := repos.NewAudiobookRepo(db).RebuildSuggestions(ctx)
+			return err
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return db.Collection("suggestions").Drop(ctx)
+		},
+	},
+	{
+		Version:     5,
+		Description: "move view counts from the books to the views collection",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			cursor, err := db.Collection("audiobooks").Aggregate(ctx, mongo.Pipeline{
+				{{Key: "$match", Value: bson.D{{Key: "popularity", Value: bson.M{"$gt": 0}}}}},
+				{{Key: "$project", Value: bson.D{{Key: "_id", Value: "$id"}, {Key: "count", Value: "$popularity"}}}},
+				{{Key: "$merge", Value: bson.D{{Key: "into", Value: repos.ViewsCollection}, {Key: "whenMatched", Value: "keepExisting"}}}},
+			})
+			if err != nil {
+				return err
+			}
+			return cursor.Close(ctx)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return db.Collection(repos.ViewsCollection).Drop(ctx)
+		},
+	},
+	{
+		Version:     6,
+		Description: "replace the terms index of suggestions with terms_weight_text",
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			suggestions := db.Collection("suggestions")
+			if _, err := repos.ReconcileIndexes(ctx, suggestions, repos.SuggestionIndexes(), false); err != nil {
+				return err
+			}
+			return dropIndexIfExists(ctx, suggestions, "terms")
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			suggestions := db.Collection("suggestions")
+			if _, err := suggestions.Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys:    bson.D{{Key: "terms", Value: 1}},
+				Options: options.Index().SetName("terms"),
+			}); err != nil {
+				return err
+			}
+			return dropIndexIfExists(ctx, suggestions, "terms_weight_text")
+		},
+	},
+}
+
+// dropIndexIfExists drops the named index, a missing index or collection is not an error
+func dropIndexIfExists(ctx context.Context, collection *mongo.Collection, name string) error {
+	_, err := collection.Indexes().DropOne(ctx, name)
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && (cmdErr.Code == namespaceNotFound || cmdErr.Code == indexNotFound) {
+		return nil
+	}
+	return err
+}
+
+// Mongo error codes for a collection and an index that don't exist
+const (
+	namespaceNotFound = 26
+	indexNotFound     = 27
+)
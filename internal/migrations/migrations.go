@@ -0,0 +1,180 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection records the applied migrations, and while a migration runs the lock document
+const Collection = "schema_migrations"
+
+const lockID = "lock"
+
+// Migration is one versioned schema change. Down is nil for changes that can't be undone
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, db *mongo.Database) error
+	Down        func(ctx context.Context, db *mongo.Database) error
+}
+
+// Record is the schema_migrations document written once a migration has been applied
+type Record struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"`
+}
+
+type lock struct {
+	ID       string    `bson:"_id"`
+	Owner    string    `bson:"owner"`
+	LockedAt time.Time `bson:"locked_at"`
+}
+
+// ErrLocked means another instance holds the migration lock
+var ErrLocked = errors.New("migrations are locked")
+
+// Latest is the schema version this build knows about
+func Latest() int {
+	return all[len(all)-1].Version
+}
+
+// All returns the known migrations in the order they are applied
+func All() []Migration {
+	return all
+}
+
+// Applied returns the records of every applied migration, oldest first
+func Applied(ctx context.Context, db *mongo.Database) ([]Record, error) {
+	cursor, err := db.Collection(Collection).Find(ctx,
+		bson.D{{Key: "_id", Value: bson.M{"$type": "number"}}},
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	records := []Record{}
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// Current is the version of the last applied migration, 0 for a database that was never migrated
+func Current(ctx context.Context, db *mongo.Database) (int, error) {
+	records, err := Applied(ctx, db)
+	if err != nil || len(records) == 0 {
+		return 0, err
+	}
+	return records[len(records)-1].Version, nil
+}
+
+// Check refuses a database migrated past the version this build knows, it would
+// misread whatever the newer migrations changed
+func Check(ctx context.Context, db *mongo.Database) (int, error) {
+	current, err := Current(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+	if current > Latest() {
+		return current, fmt.Errorf("database schema is at version %d but this build only knows up to %d", current, Latest())
+	}
+	return current, nil
+}
+
+// Lock takes the migration lock, failing with ErrLocked when another instance has it
+func Lock(ctx context.Context, db *mongo.Database) error {
+	host, _ := os.Hostname()
+	_, err := db.Collection(Collection).InsertOne(ctx, lock{
+		ID:       lockID,
+		Owner:    fmt.Sprintf("%s:%d", host, os.Getpid()),
+		LockedAt: time.Now(),
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		var held lock
+		if err := db.Collection(Collection).FindOne(ctx, bson.D{{Key: "_id", Value: lockID}}).Decode(&held); err != nil {
+			return ErrLocked
+		}
+		return fmt.Errorf("%w by %s since %s", ErrLocked, held.Owner, held.LockedAt.Format(time.RFC3339))
+	}
+	return err
+}
+
+// Unlock releases the migration lock, whoever holds it
+func Unlock(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection(Collection).DeleteOne(ctx, bson.D{{Key: "_id", Value: lockID}})
+	return err
+}
+
+// Up applies the pending migrations up to and including target, every pending one when target is 0.
+// It returns the migrations it applied, stopping at the first one that fails
+func Up(ctx context.Context, db *mongo.Database, target int) ([]Migration, error) {
+	current, err := Check(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	if target == 0 {
+		target = Latest()
+	}
+
+	var applied []Migration
+	for _, m := range all {
+		if m.Version <= current || m.Version > target {
+			continue
+		}
+		if err := m.Up(ctx, db); err != nil {
+			return applied, fmt.Errorf("migration %d (%s): %w", m.Version, m.Description, err)
+		}
+		_, err := db.Collection(Collection).InsertOne(ctx, Record{Version: m.Version, Description: m.Description, AppliedAt: time.Now()})
+		if err != nil {
+			return applied, err
+		}
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+// Down reverts the last steps applied migrations, newest first
+func Down(ctx context.Context, db *mongo.Database, steps int) ([]Migration, error) {
+	if _, err := Check(ctx, db); err != nil {
+		return nil, err
+	}
+	records, err := Applied(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	var reverted []Migration
+	for i := len(records) - 1; i >= 0 && len(reverted) < steps; i-- {
+		m, ok := find(records[i].Version)
+		if !ok {
+			return reverted, fmt.Errorf("migration %d is not known to this build", records[i].Version)
+		}
+		if m.Down == nil {
+			return reverted, fmt.Errorf("migration %d (%s) can't be reverted", m.Version, m.Description)
+		}
+		if err := m.Down(ctx, db); err != nil {
+			return reverted, fmt.Errorf("reverting migration %d (%s): %w", m.Version, m.Description, err)
+		}
+		if _, err := db.Collection(Collection).DeleteOne(ctx, bson.D{{Key: "_id", Value: m.Version}}); err != nil {
+			return reverted, err
+		}
+		reverted = append(reverted, m)
+	}
+	return reverted, nil
+}
+
+func find(version int) (Migration, bool) {
+	for _, m := range all {
+		if m.Version == version {
+			return m, true
+		}
+	}
+	return Migration{}, false
+}
@@ -0,0 +1,21 @@
+package migrations
+
+import "testing"
+
+// versions have to run 1, 2, 3... for Up and Down to find their place
+func TestVersionsAreSequential(t *testing.T) {
+	for i, m := range All() {
+		if m.Version != i+1 {
+			t.Errorf("migration %d has version %d", i, m.Version)
+		}
+		if m.Up == nil {
+			t.Errorf("migration %d has no Up", m.Version)
+		}
+		if m.Description == "" {
+			t.Errorf("migration %d has no description", m.Version)
+		}
+	}
+	if Latest() != len(All()) {
+		t.Errorf("Latest() = %d, want %d", Latest(), len(All()))
+	}
+}
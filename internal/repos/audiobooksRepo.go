@@ -2,10 +2,11 @@ package repos
 
 import (
 	"context"
-	"log"
+	"errors"
 	"math"
 
 	Error "github.com/mayank12gt/free-audiobooks-backend/internal/errors"
+	"github.com/mayank12gt/free-audiobooks-backend/internal/logging"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -13,7 +14,8 @@ import (
 )
 
 type AudiobooksRepo struct {
-	DB *mongo.Database
+	DB       *mongo.Database
+	Timeouts Timeouts
 }
 
 type AudiobookDTO struct {
@@ -64,6 +66,9 @@ type Audiobook struct {
 	Sections      []Section          `bson:"sections" json:"sections,omitempty"`
 	Genres        []Genre            `bson:"genres" json:"genres"`
 	Translators   []Translator       `bson:"translators" json:"translators"`
+	Popularity    int                `bson:"popularity" json:"popularity"`
+	Score         float64            `bson:"score,omitempty" json:"score,omitempty"`
+	Highlights    *Highlights        `bson:"-" json:"highlights,omitempty"`
 }
 
 type Author struct {
@@ -97,21 +102,24 @@ type Section struct {
 }
 
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	PrevCursor   string `json:"prev_cursor,omitempty"`
 }
 
-func NewAudiobookRepo(db *mongo.Database) AudiobooksRepo {
-	return AudiobooksRepo{
-		DB: db,
+func NewAudiobookRepo(db *mongo.Database) *AudiobooksRepo {
+	return &AudiobooksRepo{
+		DB:       db,
+		Timeouts: DefaultTimeouts,
 	}
 }
 
 func calculateMetadata(totalRecords, page, pageSize int) Metadata {
-	if totalRecords == 0 {
+	if totalRecords == 0 || pageSize < 1 {
 		return Metadata{}
 	}
 	return Metadata{
@@ -123,115 +131,262 @@ func calculateMetadata(totalRecords, page, pageSize int) Metadata {
 	}
 }
 
-func (m *AudiobooksRepo) List(search string, genres []string, language string, totalTimeMin, totalTimeMax int64, page, page_size int64, sortBy string) ([]*Audiobook, Metadata, error) {
+func (m *AudiobooksRepo) List(ctx context.Context, f Filter, page, page_size int64, sortBy string) ([]*Audiobook, Metadata, error) {
 
 	collection := m.DB.Collection("audiobooks")
 
-	filter := bson.D{}
-	options := options.Find().SetProjection(bson.D{{"sections", 0}, {"translators", 0}}).SetSkip((page - 1) * page_size).SetLimit(page_size)
+	timeout := m.Timeouts.searchTimeout(f)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	filter := f.bson("")
+	logging.FromContext(ctx).Debug("listing audiobooks", "filter", filter, "page", page, "page_size", page_size, "sort_by", sortBy)
+
+	countOptions := options.Count().SetMaxTime(timeout)
+	options := options.Find().SetMaxTime(timeout).SetProjection(listProjection(f)).SetSkip((page - 1) * page_size).SetLimit(page_size)
+
+	options = options.SetSort(sortDoc(sortKeys(sortBy)))
 
-	if search != "" {
-		log.Print(search)
-		// filter = bson.D{{Key: "title", Value: search}}
-		filter = append(filter, bson.E{Key: "$text", Value: bson.D{{Key: "$search", Value: search}}})
-		log.Print(filter)
+	done := observe(ctx, "List", "count", f.Attributes()...)
+	count, err := collection.CountDocuments(ctx, filter, countOptions)
+	done(err)
+	if err != nil {
+		return nil, Metadata{}, dbError(ctx, err)
+	}
+	if count == 0 {
+		return []*Audiobook{}, Metadata{}, nil
 	}
 
-	if len(genres) != 0 {
-		log.Print(genres)
-		filter = append(filter, bson.E{Key: "genres.id", Value: bson.M{"$in": genres}})
+	meta := calculateMetadata(int(count), int(page), int(page_size))
+
+	done = observe(ctx, "List", "find", f.Attributes()...)
+	cursor, err := collection.Find(ctx, filter, options)
+	if err != nil {
+		done(err)
+		return nil, Metadata{}, dbError(ctx, err)
 	}
+	defer cursor.Close(ctx)
 
-	if language != "" {
-		log.Print(language)
-		filter = append(filter, bson.E{Key: "language", Value: language})
+	audiobooks := []*Audiobook{}
+	err = cursor.All(ctx, &audiobooks)
+	done(err)
+	if err != nil {
+		return nil, Metadata{}, dbError(ctx, err)
 	}
 
-	if totalTimeMax != 0 && totalTimeMin != 0 {
-		log.Print(totalTimeMin)
-		log.Print(totalTimeMax)
+	return audiobooks, meta, nil
+}
 
-		filter = append(filter, bson.E{Key: "totaltimesecs", Value: bson.M{"$gte": totalTimeMin, "$lte": totalTimeMax}})
-	}
-	if sortBy != "" {
-		log.Print("sort" + sortBy)
+// ListCursor pages through the list by keyset instead of skip, after is the cursor returned
+// with the previous page and is empty for the first page
+func (m *AudiobooksRepo) ListCursor(ctx context.Context, f Filter, after string, page_size int64, sortBy string) ([]*Audiobook, Metadata, error) {
 
-		options = options.SetSort(bson.D{{sortBy, 1}})
-	} else {
-		log.Print(sortBy)
-		options = options.SetSort(bson.D{{"_id", 1}})
+	collection := m.DB.Collection("audiobooks")
+
+	timeout := m.Timeouts.searchTimeout(f)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	keys := sortKeys(sortBy)
+	filter := f.bson("")
+
+	var c *pageCursor
+	var err error
+	if after != "" {
+		c, err = decodeCursor(after, sortBy, keys)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
 	}
 
-	count, err := collection.CountDocuments(context.TODO(), filter)
+	done := observe(ctx, "ListCursor", "count", f.Attributes()...)
+	count, err := collection.CountDocuments(ctx, filter, options.Count().SetMaxTime(timeout))
+	done(err)
 	if err != nil {
-		return nil, Metadata{}, Error.NewError().Set("server", "Internal Server Error")
+		return nil, Metadata{}, dbError(ctx, err)
 	}
 	if count == 0 {
-		return nil, Metadata{}, Error.NewError().Set("message", "No records found")
+		return []*Audiobook{}, Metadata{}, nil
 	}
 
-	meta := calculateMetadata(int(count), int(page), int(page_size))
+	// the text score can't be compared in a Find filter, so pages are fetched through a
+	// pipeline that adds it as a field before the keyset match
+	pipeline := bson.A{bson.M{"$match": filter}}
+	if f.Search != "" {
+		pipeline = append(pipeline, bson.M{"$addFields": bson.M{"score": bson.M{"$meta": "textScore"}}})
+	}
+
+	fetchKeys := keys
+	if c != nil {
+		if c.Before {
+			fetchKeys = reversed(keys)
+		}
+		pipeline = append(pipeline, bson.M{"$match": bson.M{"$or": keysetFilter(fetchKeys, c.Values)}})
+	}
+
+	pipeline = append(pipeline,
+		bson.M{"$sort": pipelineSortDoc(fetchKeys)},
+		bson.M{"$limit": page_size + 1},
+		bson.M{"$project": bson.D{{Key: "sections", Value: 0}, {Key: "translators", Value: 0}}},
+	)
 
-	cursor, err := collection.Find(context.TODO(), filter, options)
+	done = observe(ctx, "ListCursor", "aggregate", f.Attributes()...)
+	cursor, err := collection.Aggregate(ctx, pipeline, options.Aggregate().SetMaxTime(timeout))
 	if err != nil {
-		return nil, Metadata{}, err
+		done(err)
+		return nil, Metadata{}, dbError(ctx, err)
 	}
-	defer cursor.Close(context.Background())
+	defer cursor.Close(ctx)
 
-	var audiobooks []*Audiobook
-	if err = cursor.All(context.TODO(), &audiobooks); err != nil {
-		return nil, Metadata{}, err
+	var rows []*Audiobook
+	err = cursor.All(ctx, &rows)
+	done(err)
+	if err != nil {
+		return nil, Metadata{}, dbError(ctx, err)
 	}
 
-	return audiobooks, meta, nil
+	audiobooks, next, prev := cursorPage(rows, c, sortBy, keys, page_size)
+
+	return audiobooks, Metadata{
+		PageSize:     int(page_size),
+		TotalRecords: int(count),
+		NextCursor:   next,
+		PrevCursor:   prev,
+	}, nil
+}
+
+// listProjection leaves out the bulky fields of list results and adds the text score when searching
+func listProjection(f Filter) bson.D {
+	projection := bson.D{{Key: "sections", Value: 0}, {Key: "translators", Value: 0}}
+	if f.Search != "" {
+		projection = append(projection, bson.E{Key: "score", Value: bson.M{"$meta": "textScore"}})
+	}
+	return projection
 }
 
-func (m *AudiobooksRepo) Get(id string) (*Audiobook, error) {
+func (m *AudiobooksRepo) Get(ctx context.Context, id string) (*Audiobook, error) {
 
 	collection := m.DB.Collection("audiobooks")
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
 
-	filter := bson.D{{"id", id}}
+	filter := bson.D{{Key: "id", Value: id}}
 	//options := options.FindOne()
 
 	var audiobook Audiobook
-	err := collection.FindOne(context.TODO(), filter).Decode(&audiobook)
+	done := observe(ctx, "Get", "find_one")
+	err := collection.FindOne(ctx, filter, options.FindOne().SetMaxTime(m.Timeouts.Query)).Decode(&audiobook)
+	done(err)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, Error.NotFound("audiobook not found")
+	}
 	if err != nil {
-
-		return nil, Error.NewError().Set("client", "record not found")
+		return nil, dbError(ctx, err)
 	}
 
 	return &audiobook, nil
 
 }
 
-func (m *AudiobooksRepo) GetGenres(page, page_size int64) ([]*GenreDTO, Metadata, error) {
+func (m *AudiobooksRepo) GetGenres(ctx context.Context, page, page_size int64) ([]*GenreDTO, Metadata, error) {
 
 	collection := m.DB.Collection("genres")
+
+	timeout := m.Timeouts.Query
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 	filter := bson.D{}
 
-	options := options.Find().SetSkip((page - 1) * page_size).SetLimit(page_size)
+	countOptions := options.Count().SetMaxTime(timeout)
+	options := options.Find().SetMaxTime(timeout).SetSkip((page - 1) * page_size).SetLimit(page_size)
 
-	count, err := collection.CountDocuments(context.TODO(), filter)
+	done := observe(ctx, "GetGenres", "count")
+	count, err := collection.CountDocuments(ctx, filter, countOptions)
+	done(err)
 	if err != nil {
-		return nil, Metadata{}, Error.NewError().Set("server", "Internal Server Error")
+		return nil, Metadata{}, dbError(ctx, err)
 	}
 	if count == 0 {
-		return nil, Metadata{}, Error.NewError().Set("message", "No records found")
+		return []*GenreDTO{}, Metadata{}, nil
 	}
 
 	meta := calculateMetadata(int(count), int(page), int(page_size))
 
-	cursor, err := collection.Find(context.TODO(), filter, options)
+	done = observe(ctx, "GetGenres", "find")
+	cursor, err := collection.Find(ctx, filter, options)
 	if err != nil {
-		return nil, Metadata{}, err
+		done(err)
+		return nil, Metadata{}, dbError(ctx, err)
 	}
-	defer cursor.Close(context.Background())
+	defer cursor.Close(ctx)
 
-	var genres []*GenreDTO
-	if err = cursor.All(context.TODO(), &genres); err != nil {
-		return nil, Metadata{}, err
+	genres := []*GenreDTO{}
+	err = cursor.All(ctx, &genres)
+	done(err)
+	if err != nil {
+		return nil, Metadata{}, dbError(ctx, err)
 	}
 
 	return genres, meta, nil
 
 }
+
+func (m *AudiobooksRepo) GetSimilar(ctx context.Context, id string, page, page_size int64) ([]*Audiobook, Metadata, error) {
+
+	book, err := m.Get(ctx, id)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	collection := m.DB.Collection("audiobooks")
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Search)
+	defer cancel()
+
+	var or bson.A
+	if ids := genreIDs(book.Genres); len(ids) != 0 {
+		or = append(or, bson.M{"genres.id": bson.M{"$in": ids}})
+	}
+	if ids := authorIDs(book.Authors); len(ids) != 0 {
+		or = append(or, bson.M{"authors.id": bson.M{"$in": ids}})
+	}
+	if ids := translatorIDs(book.Translators); len(ids) != 0 {
+		or = append(or, bson.M{"translators.id": bson.M{"$in": ids}})
+	}
+
+	filter := bson.D{{Key: "id", Value: bson.M{"$ne": book.IDStr}}}
+	if len(or) != 0 {
+		filter = append(filter, bson.E{Key: "$or", Value: or})
+	} else {
+		filter = append(filter, bson.E{Key: "language", Value: book.Language})
+	}
+
+	// the closest candidates by shared ids and language go first, so the cap never depends on storage order
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$addFields", Value: bson.D{{Key: "overlap", Value: overlapExpr(book)}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "overlap", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: similarCandidateLimit}},
+		{{Key: "$project", Value: bson.D{{Key: "sections", Value: 0}, {Key: "overlap", Value: 0}}}},
+	}
+
+	done := observe(ctx, "GetSimilar", "aggregate")
+	cursor, err := collection.Aggregate(ctx, pipeline, options.Aggregate().SetMaxTime(m.Timeouts.Search))
+	if err != nil {
+		done(err)
+		return nil, Metadata{}, dbError(ctx, err)
+	}
+	defer cursor.Close(ctx)
+
+	var candidates []*Audiobook
+	err = cursor.All(ctx, &candidates)
+	done(err)
+	if err != nil {
+		return nil, Metadata{}, dbError(ctx, err)
+	}
+	if len(candidates) == 0 {
+		return []*Audiobook{}, Metadata{}, nil
+	}
+
+	audiobooks, meta := paginate(rankSimilar(book, candidates), page, page_size)
+	return audiobooks, meta, nil
+}
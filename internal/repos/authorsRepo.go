@@ -0,0 +1,199 @@
+package repos
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+
+	Error "github.com/mayank12gt/free-audiobooks-backend/internal/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type AuthorDTO struct {
+	ID        string `bson:"_id" json:"id"`
+	FirstName string `bson:"first_name" json:"first_name"`
+	LastName  string `bson:"last_name" json:"last_name"`
+	DOB       string `bson:"dob" json:"dob"`
+	DOD       string `bson:"dod" json:"dod"`
+	Lifespan  string `bson:"-" json:"lifespan,omitempty"`
+	BookCount int    `bson:"book_count" json:"book_count"`
+}
+
+// lifespan formats birth and death years as "1775-1817", "b. 1775" or "d. 1817"
+func lifespan(dob, dod string) string {
+	known := func(year string) bool {
+		y, err := strconv.Atoi(year)
+		return err == nil && y != 0
+	}
+
+	switch {
+	case known(dob) && known(dod):
+		return dob + "-" + dod
+	case known(dob):
+		return "b. " + dob
+	case known(dod):
+		return "d. " + dod
+	}
+	return ""
+}
+
+// authorsPipeline groups the embedded authors of every audiobook into one document per author id
+func authorsPipeline() bson.A {
+	return bson.A{
+		bson.M{"$unwind": "$authors"},
+		bson.M{"$group": bson.M{
+			"_id":        "$authors.id",
+			"first_name": bson.M{"$first": "$authors.first_name"},
+			"last_name":  bson.M{"$first": "$authors.last_name"},
+			"dob":        bson.M{"$first": "$authors.dob"},
+			"dod":        bson.M{"$first": "$authors.dod"},
+			"book_count": bson.M{"$sum": 1},
+		}},
+	}
+}
+
+func (m *AudiobooksRepo) ListAuthors(ctx context.Context, search string, page, page_size int64) ([]*AuthorDTO, Metadata, error) {
+
+	collection := m.DB.Collection("audiobooks")
+
+	// listing authors groups every book by author whether or not there is a search, so it is
+	// one of the aggregations the search timeout covers and not a lookup
+	timeout := m.Timeouts.Search
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	pipeline := authorsPipeline()
+	if search != "" {
+		pipeline = append(pipeline,
+			// $concat is null when either part is missing, and many LibriVox authors have only one name
+			bson.M{"$addFields": bson.M{"name": bson.M{"$concat": bson.A{
+				bson.M{"$ifNull": bson.A{"$first_name", ""}}, " ", bson.M{"$ifNull": bson.A{"$last_name", ""}},
+			}}}},
+			bson.M{"$match": bson.M{"name": bson.M{"$regex": regexp.QuoteMeta(search), "$options": "i"}}},
+		)
+	}
+	pipeline = append(pipeline, bson.M{"$facet": bson.M{
+		"total": bson.A{bson.M{"$count": "count"}},
+		"authors": bson.A{
+			bson.M{"$sort": bson.D{{Key: "last_name", Value: 1}, {Key: "first_name", Value: 1}, {Key: "_id", Value: 1}}},
+			bson.M{"$skip": (page - 1) * page_size},
+			bson.M{"$limit": page_size},
+		},
+	}})
+
+	done := observe(ctx, "ListAuthors", "aggregate")
+	cursor, err := collection.Aggregate(ctx, pipeline, options.Aggregate().SetMaxTime(timeout))
+	if err != nil {
+		done(err)
+		return nil, Metadata{}, dbError(ctx, err)
+	}
+	defer cursor.Close(ctx)
+
+	var result []struct {
+		Total []struct {
+			Count int `bson:"count"`
+		} `bson:"total"`
+		Authors []*AuthorDTO `bson:"authors"`
+	}
+	err = cursor.All(ctx, &result)
+	done(err)
+	if err != nil {
+		return nil, Metadata{}, dbError(ctx, err)
+	}
+	if len(result) == 0 || len(result[0].Total) == 0 {
+		return []*AuthorDTO{}, Metadata{}, nil
+	}
+
+	authors := result[0].Authors
+	for _, a := range authors {
+		a.Lifespan = lifespan(a.DOB, a.DOD)
+	}
+
+	meta := calculateMetadata(result[0].Total[0].Count, int(page), int(page_size))
+
+	return authors, meta, nil
+}
+
+func (m *AudiobooksRepo) GetAuthor(ctx context.Context, id string) (*AuthorDTO, error) {
+
+	collection := m.DB.Collection("audiobooks")
+
+	timeout := m.Timeouts.Query
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	pipeline := bson.A{bson.M{"$match": bson.M{"authors.id": id}}}
+	pipeline = append(pipeline, authorsPipeline()...)
+	pipeline = append(pipeline, bson.M{"$match": bson.M{"_id": id}})
+
+	done := observe(ctx, "GetAuthor", "aggregate")
+	cursor, err := collection.Aggregate(ctx, pipeline, options.Aggregate().SetMaxTime(timeout))
+	if err != nil {
+		done(err)
+		return nil, dbError(ctx, err)
+	}
+	defer cursor.Close(ctx)
+
+	var authors []*AuthorDTO
+	err = cursor.All(ctx, &authors)
+	done(err)
+	if err != nil {
+		return nil, dbError(ctx, err)
+	}
+	if len(authors) == 0 {
+		return nil, Error.NotFound("author not found")
+	}
+
+	author := authors[0]
+	author.Lifespan = lifespan(author.DOB, author.DOD)
+
+	return author, nil
+}
+
+func (m *AudiobooksRepo) ListByAuthor(ctx context.Context, id string, page, page_size int64) ([]*Audiobook, Metadata, error) {
+
+	collection := m.DB.Collection("audiobooks")
+
+	timeout := m.Timeouts.Query
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	filter := bson.D{{Key: "authors.id", Value: id}}
+	countOptions := options.Count().SetMaxTime(timeout)
+	options := options.Find().
+		SetMaxTime(timeout).
+		SetProjection(bson.D{{Key: "sections", Value: 0}, {Key: "translators", Value: 0}}).
+		SetSort(bson.D{{Key: "title", Value: 1}, {Key: "_id", Value: 1}}).
+		SetSkip((page - 1) * page_size).
+		SetLimit(page_size)
+
+	done := observe(ctx, "ListByAuthor", "count")
+	count, err := collection.CountDocuments(ctx, filter, countOptions)
+	done(err)
+	if err != nil {
+		return nil, Metadata{}, dbError(ctx, err)
+	}
+	if count == 0 {
+		return []*Audiobook{}, Metadata{}, nil
+	}
+
+	meta := calculateMetadata(int(count), int(page), int(page_size))
+
+	done = observe(ctx, "ListByAuthor", "find")
+	cursor, err := collection.Find(ctx, filter, options)
+	if err != nil {
+		done(err)
+		return nil, Metadata{}, dbError(ctx, err)
+	}
+	defer cursor.Close(ctx)
+
+	audiobooks := []*Audiobook{}
+	err = cursor.All(ctx, &audiobooks)
+	done(err)
+	if err != nil {
+		return nil, Metadata{}, dbError(ctx, err)
+	}
+
+	return audiobooks, meta, nil
+}
@@ -0,0 +1,26 @@
+package repos
+
+import "testing"
+
+func TestLifespan(t *testing.T) {
+	tests := []struct {
+		dob, dod string
+		want     string
+	}{
+		{"1775", "1817", "1775-1817"},
+		{"1775", "", "b. 1775"},
+		{"", "1817", "d. 1817"},
+		{"0", "1817", "d. 1817"},
+		{"1775", "0", "b. 1775"},
+		{"", "", ""},
+		{"0", "0", ""},
+		{"unknown", "c. 1200", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dob+"_"+tt.dod, func(t *testing.T) {
+			if got := lifespan(tt.dob, tt.dod); got != tt.want {
+				t.Errorf("lifespan(%q, %q) = %q, want %q", tt.dob, tt.dod, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package repos
+
+import (
+	"encoding/base64"
+
+	Error "github.com/mayank12gt/free-audiobooks-backend/internal/errors"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// pageCursor marks the boundary document of a page, it is handed to clients as an opaque string
+type pageCursor struct {
+	Sort   string `bson:"s"`
+	Values bson.A `bson:"v"`
+	Before bool   `bson:"b,omitempty"`
+}
+
+func encodeCursor(c pageCursor) string {
+	raw, err := bson.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(s string, sortBy string, keys []SortKey) (*pageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, Error.NewError().Set("cursor", "invalid cursor")
+	}
+
+	var c pageCursor
+	if err := bson.Unmarshal(raw, &c); err != nil || len(c.Values) != len(keys) {
+		return nil, Error.NewError().Set("cursor", "invalid cursor")
+	}
+	if c.Sort != sortBy {
+		return nil, Error.NewError().Set("cursor", "cursor was issued for a different sort_by")
+	}
+
+	return &c, nil
+}
+
+// keysetFilter matches documents strictly after values in the order given by keys
+func keysetFilter(keys []SortKey, values bson.A) bson.A {
+	or := bson.A{}
+	for i, k := range keys {
+		clause := bson.D{}
+		for j := 0; j < i; j++ {
+			clause = append(clause, bson.E{Key: keys[j].Field, Value: values[j]})
+		}
+		op := "$gt"
+		if k.Desc {
+			op = "$lt"
+		}
+		clause = append(clause, bson.E{Key: k.Field, Value: bson.M{op: values[i]}})
+		or = append(or, clause)
+	}
+	return or
+}
+
+// cursorValues reads the sort key values of a book to store in a cursor
+func cursorValues(a *Audiobook, keys []SortKey) bson.A {
+	values := bson.A{}
+	for _, k := range keys {
+		values = append(values, sortValue(a, k.Field))
+	}
+	return values
+}
+
+// cursorPage trims a page fetched with one extra row and builds the cursors around it,
+// rows were fetched in reverse order when c.Before is set
+func cursorPage(rows []*Audiobook, c *pageCursor, sortBy string, keys []SortKey, page_size int64) ([]*Audiobook, string, string) {
+	more := int64(len(rows)) > page_size
+	if more {
+		rows = rows[:page_size]
+	}
+
+	if c != nil && c.Before {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+
+	if len(rows) == 0 {
+		return []*Audiobook{}, "", ""
+	}
+
+	var next, prev string
+	hasNext := more
+	hasPrev := c != nil
+	if c != nil && c.Before {
+		hasNext, hasPrev = true, more
+	}
+
+	if hasNext {
+		next = encodeCursor(pageCursor{Sort: sortBy, Values: cursorValues(rows[len(rows)-1], keys)})
+	}
+	if hasPrev {
+		prev = encodeCursor(pageCursor{Sort: sortBy, Values: cursorValues(rows[0], keys), Before: true})
+	}
+
+	return rows, next, prev
+}
@@ -0,0 +1,132 @@
+package repos
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+type FacetCount struct {
+	Value string `bson:"_id" json:"value"`
+	Name  string `bson:"name,omitempty" json:"name,omitempty"`
+	Count int    `bson:"count" json:"count"`
+}
+
+// LengthBucket counts books whose length falls in [Min, Max) minutes, Max is 0 for the open ended last bucket
+type LengthBucket struct {
+	Min   int64 `json:"min"`
+	Max   int64 `json:"max,omitempty"`
+	Count int   `json:"count"`
+}
+
+type Facets struct {
+	Genres    []FacetCount   `json:"genres,omitempty"`
+	Languages []FacetCount   `json:"languages,omitempty"`
+	Length    []LengthBucket `json:"length,omitempty"`
+}
+
+const maxGenreFacets = 50
+
+// lengthBoundaries are the lower bounds of the length buckets in seconds
+var lengthBoundaries = []int64{0, 3600, 3 * 3600, 5 * 3600, 10 * 3600, 20 * 3600}
+
+// lengthBucketsFromCounts turns counts keyed by lower bound into LengthBuckets, skipping empty ones
+func lengthBucketsFromCounts(counts map[int64]int) []LengthBucket {
+	buckets := []LengthBucket{}
+	for i, lower := range lengthBoundaries {
+		if counts[lower] == 0 {
+			continue
+		}
+		bucket := LengthBucket{Min: lower / 60, Count: counts[lower]}
+		if i+1 < len(lengthBoundaries) {
+			bucket.Max = lengthBoundaries[i+1] / 60
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets
+}
+
+func (m *AudiobooksRepo) Facets(ctx context.Context, f Filter, facets []string) (*Facets, error) {
+
+	collection := m.DB.Collection("audiobooks")
+
+	timeout := m.Timeouts.Search
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	result := &Facets{}
+
+	for _, facet := range facets {
+		pipeline := bson.A{bson.M{"$match": f.bson(facet)}}
+
+		switch facet {
+		case GenresFacet:
+			pipeline = append(pipeline,
+				bson.M{"$unwind": "$genres"},
+				bson.M{"$group": bson.M{"_id": "$genres.id", "name": bson.M{"$first": "$genres.name"}, "count": bson.M{"$sum": 1}}},
+				bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+				bson.M{"$limit": maxGenreFacets},
+			)
+		case LanguageFacet:
+			pipeline = append(pipeline,
+				bson.M{"$group": bson.M{"_id": "$language", "count": bson.M{"$sum": 1}}},
+				bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+			)
+		case LengthFacet:
+			boundaries := bson.A{}
+			for _, b := range lengthBoundaries {
+				boundaries = append(boundaries, b)
+			}
+			boundaries = append(boundaries, int64(1)<<40)
+			pipeline = append(pipeline,
+				bson.M{"$bucket": bson.M{
+					"groupBy":    "$totaltimesecs",
+					"boundaries": boundaries,
+					"default":    "other",
+					"output":     bson.M{"count": bson.M{"$sum": 1}},
+				}},
+			)
+		default:
+			continue
+		}
+
+		done := observe(ctx, "Facets", "aggregate", append(f.Attributes(), attribute.String("facet", facet))...)
+		cursor, err := collection.Aggregate(ctx, pipeline, options.Aggregate().SetMaxTime(timeout))
+		if err != nil {
+			done(err)
+			return nil, dbError(ctx, err)
+		}
+
+		switch facet {
+		case GenresFacet:
+			result.Genres = []FacetCount{}
+			err = cursor.All(ctx, &result.Genres)
+		case LanguageFacet:
+			result.Languages = []FacetCount{}
+			err = cursor.All(ctx, &result.Languages)
+		case LengthFacet:
+			var buckets []struct {
+				Lower interface{} `bson:"_id"`
+				Count int         `bson:"count"`
+			}
+			err = cursor.All(ctx, &buckets)
+			counts := make(map[int64]int)
+			for _, b := range buckets {
+				switch lower := b.Lower.(type) {
+				case int64:
+					counts[lower] = b.Count
+				case int32:
+					counts[int64(lower)] = b.Count
+				}
+			}
+			result.Length = lengthBucketsFromCounts(counts)
+		}
+		done(err)
+		if err != nil {
+			return nil, dbError(ctx, err)
+		}
+	}
+
+	return result, nil
+}
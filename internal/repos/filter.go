@@ -0,0 +1,62 @@
+package repos
+
+import (
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Filter holds the audiobook filters shared by List and Facets
+type Filter struct {
+	Search       string
+	Genres       []string
+	Language     string
+	TotalTimeMin int64
+	TotalTimeMax int64
+}
+
+// names of the filters a facet can leave out
+const (
+	GenresFacet   = "genres"
+	LanguageFacet = "language"
+	LengthFacet   = "length"
+)
+
+// Attributes describe the shape of the filter for tracing, which filters are set but never the search text
+func (f Filter) Attributes() []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.Bool("filter.search", f.Search != ""),
+		attribute.Int("filter.search_words", len(strings.Fields(f.Search))),
+		attribute.Int("filter.genres", len(f.Genres)),
+		attribute.Bool("filter.language", f.Language != ""),
+		attribute.Bool("filter.length", f.hasLength()),
+	}
+}
+
+func (f Filter) hasLength() bool {
+	return f.TotalTimeMax != 0 && f.TotalTimeMin != 0
+}
+
+// bson builds the Mongo filter, leaving out the clause named by exclude
+func (f Filter) bson(exclude string) bson.D {
+	filter := bson.D{}
+
+	if f.Search != "" {
+		filter = append(filter, bson.E{Key: "$text", Value: bson.D{{Key: "$search", Value: f.Search}}})
+	}
+
+	if len(f.Genres) != 0 && exclude != GenresFacet {
+		filter = append(filter, bson.E{Key: "genres.id", Value: bson.M{"$in": f.Genres}})
+	}
+
+	if f.Language != "" && exclude != LanguageFacet {
+		filter = append(filter, bson.E{Key: "language", Value: f.Language})
+	}
+
+	if f.hasLength() && exclude != LengthFacet {
+		filter = append(filter, bson.E{Key: "totaltimesecs", Value: bson.M{"$gte": f.TotalTimeMin, "$lte": f.TotalTimeMax}})
+	}
+
+	return filter
+}
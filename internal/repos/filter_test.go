@@ -0,0 +1,16 @@
+package repos
+
+import (
+	"strings"
+	"testing"
+)
+
+// the search text is what users typed, it mustn't end up in traces
+func TestFilterAttributesLeaveOutSearchText(t *testing.T) {
+	f := Filter{Search: "white whale", Language: "English", Genres: []string{"g1"}}
+	for _, attr := range f.Attributes() {
+		if strings.Contains(attr.Value.Emit(), "whale") {
+			t.Errorf("%s = %s", attr.Key, attr.Value.Emit())
+		}
+	}
+}
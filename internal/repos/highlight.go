@@ -0,0 +1,151 @@
+package repos
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Highlights shows which parts of a book matched the search, matched words are wrapped in <em>
+type Highlights struct {
+	Title       string   `json:"title,omitempty"`
+	Authors     []string `json:"authors,omitempty"`
+	Description []string `json:"description,omitempty"`
+}
+
+const (
+	snippetRadius = 8
+	maxSnippets   = 3
+)
+
+var tagPattern = regexp.MustCompile(`<[^>]*>`)
+
+type word struct {
+	text  string
+	match bool
+}
+
+// splitWords splits text into words and the separators between them, marking words whose stem is in terms
+func splitWords(text string, terms map[string]bool) []word {
+	var words []word
+	var current strings.Builder
+	inWord := false
+
+	flush := func() {
+		if current.Len() == 0 {
+			return
+		}
+		w := word{text: current.String()}
+		if inWord {
+			w.match = terms[stem(strings.ToLower(w.text))]
+		}
+		words = append(words, w)
+		current.Reset()
+	}
+
+	for _, r := range text {
+		isWordRune := unicode.IsLetter(r) || unicode.IsNumber(r)
+		if isWordRune != inWord {
+			flush()
+			inWord = isWordRune
+		}
+		current.WriteRune(r)
+	}
+	flush()
+
+	return words
+}
+
+func render(words []word) string {
+	var b strings.Builder
+	for _, w := range words {
+		if w.match {
+			b.WriteString("<em>" + html.EscapeString(w.text) + "</em>")
+		} else {
+			b.WriteString(html.EscapeString(w.text))
+		}
+	}
+	return b.String()
+}
+
+func anyMatch(words []word) bool {
+	for _, w := range words {
+		if w.match {
+			return true
+		}
+	}
+	return false
+}
+
+// snippets cuts windows of words around the matches in text, merging windows that overlap
+func snippets(text string, terms map[string]bool) []string {
+	text = strings.Join(strings.Fields(tagPattern.ReplaceAllString(text, " ")), " ")
+	words := splitWords(text, terms)
+
+	var result []string
+	end := -1
+	for i, w := range words {
+		if !w.match || i <= end {
+			continue
+		}
+		start := i - 2*snippetRadius
+		if start < 0 {
+			start = 0
+		}
+		if start <= end {
+			start = end + 1
+		}
+		end = i + 2*snippetRadius
+		for j := i + 1; j < len(words) && j <= end; j++ {
+			if words[j].match {
+				end = j + 2*snippetRadius
+			}
+		}
+		if end >= len(words) {
+			end = len(words) - 1
+		}
+
+		snippet := strings.TrimSpace(render(words[start : end+1]))
+		if start > 0 {
+			snippet = "…" + snippet
+		}
+		if end < len(words)-1 {
+			snippet += "…"
+		}
+		result = append(result, snippet)
+		if len(result) == maxSnippets {
+			break
+		}
+	}
+	return result
+}
+
+// Highlight works out which words of the title, author names and description matched search
+func Highlight(a *Audiobook, search string) *Highlights {
+	q := parseTextQuery(search)
+	if !q.hasTerms {
+		return nil
+	}
+
+	terms := make(map[string]bool, len(q.terms))
+	for _, t := range q.terms {
+		terms[t] = true
+	}
+
+	h := &Highlights{}
+	if words := splitWords(a.Title, terms); anyMatch(words) {
+		h.Title = render(words)
+	}
+	for _, author := range a.Authors {
+		if words := splitWords(strings.TrimSpace(author.FirstName+" "+author.LastName), terms); anyMatch(words) {
+			h.Authors = append(h.Authors, render(words))
+		}
+	}
+	h.Description = snippets(a.Description, terms)
+
+	if h.Title == "" && len(h.Authors) == 0 && len(h.Description) == 0 {
+		return nil
+	}
+	return h
+}
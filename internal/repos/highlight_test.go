@@ -0,0 +1,60 @@
+package repos
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHighlight(t *testing.T) {
+	book := &Audiobook{
+		Title:       "The Hunting of the Snark",
+		Description: "<p>An agony in eight fits & a <b>hunt</b> for the Snark</p>",
+		Authors:     []Author{{FirstName: "Lewis", LastName: "Carroll"}},
+	}
+
+	tests := []struct {
+		name   string
+		search string
+		want   *Highlights
+	}{
+		{"stems match every form", "hunts", &Highlights{
+			Title:       "The <em>Hunting</em> of the Snark",
+			Description: []string{"An agony in eight fits &amp; a <em>hunt</em> for the Snark"},
+		}},
+		{"authors", "carroll", &Highlights{Authors: []string{"Lewis <em>Carroll</em>"}}},
+		{"phrase words", `"eight fits"`, &Highlights{Description: []string{"An agony in <em>eight</em> <em>fits</em> &amp; a hunt for the Snark"}}},
+		{"no match", "whale", nil},
+		{"only negated terms", "-snark", nil},
+		{"stop words only", "the of", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Highlight(book, tt.search); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnippets(t *testing.T) {
+	long := "one two three four five six seven eight nine ten eleven twelve thirteen fourteen fifteen sixteen " +
+		"seventeen whale eighteen nineteen twenty twentyone twentytwo twentythree twentyfour twentyfive " +
+		"twentysix twentyseven twentyeight twentynine thirty thirtyone thirtytwo thirtythree"
+	got := snippets(long, map[string]bool{"whale": true})
+	if len(got) != 1 {
+		t.Fatalf("got %d snippets, want 1: %v", len(got), got)
+	}
+	// snippetRadius words either side, the cut ends marked
+	want := "…ten eleven twelve thirteen fourteen fifteen sixteen seventeen <em>whale</em> eighteen nineteen twenty twentyone twentytwo twentythree twentyfour twentyfive…"
+	if got[0] != want {
+		t.Errorf("got %q, want %q", got[0], want)
+	}
+
+	many := "whale a whale b whale"
+	for i := 0; i < 5; i++ {
+		many += " x x x x x x x x x x x x x x x x x x x x whale"
+	}
+	if got := snippets(many, map[string]bool{"whale": true}); len(got) != maxSnippets {
+		t.Errorf("got %d snippets, want at most %d", len(got), maxSnippets)
+	}
+}
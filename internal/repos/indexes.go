@@ -0,0 +1,344 @@
+package repos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AudiobookIndexes are the indexes the audiobooks collection needs for List, Get, the facets and sorting
+func AudiobookIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "id", Value: 1}},
+			Options: options.Index().SetName("id_unique").SetUnique(true),
+		},
+		{
+			Keys: bson.D{
+				{Key: "title", Value: "text"},
+				{Key: "authors.first_name", Value: "text"},
+				{Key: "authors.last_name", Value: "text"},
+				{Key: "description", Value: "text"},
+			},
+			// books carry a "language" field with values like "English" that Mongo would otherwise
+			// read as the text search language and reject when it doesn't know it
+			Options: options.Index().
+				SetName("text_search").
+				SetWeights(bson.D{
+					{Key: "title", Value: 10},
+					{Key: "authors.first_name", Value: 5},
+					{Key: "authors.last_name", Value: 5},
+					{Key: "description", Value: 1},
+				}).
+				SetDefaultLanguage("english").
+				SetLanguageOverride("text_language"),
+		},
+		{
+			Keys:    bson.D{{Key: "genres.id", Value: 1}},
+			Options: options.Index().SetName("genres_id"),
+		},
+		{
+			Keys:    bson.D{{Key: "language", Value: 1}},
+			Options: options.Index().SetName("language"),
+		},
+		{
+			Keys:    bson.D{{Key: "totaltimesecs", Value: 1}},
+			Options: options.Index().SetName("totaltimesecs"),
+		},
+		{
+			Keys:    bson.D{{Key: "authors.id", Value: 1}},
+			Options: options.Index().SetName("authors_id"),
+		},
+		{
+			Keys:    bson.D{{Key: "title", Value: 1}, {Key: "_id", Value: 1}},
+			Options: options.Index().SetName("title_sort"),
+		},
+		{
+			Keys:    bson.D{{Key: "popularity", Value: -1}, {Key: "_id", Value: 1}},
+			Options: options.Index().SetName("popularity_sort"),
+		},
+	}
+}
+
+// SuggestionIndexes back the prefix queries of Suggest
+func SuggestionIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{
+			// a prefix match on terms returns the best suggestions first without an in-memory sort
+			Keys:    bson.D{{Key: "terms", Value: 1}, {Key: "weight", Value: -1}, {Key: "text", Value: 1}},
+			Options: options.Index().SetName("terms_weight_text"),
+		},
+		{
+			Keys:    bson.D{{Key: "weight", Value: -1}, {Key: "text", Value: 1}},
+			Options: options.Index().SetName("weight_text"),
+		},
+	}
+}
+
+// namespaceNotFound is the Mongo error code for a collection that doesn't exist
+const namespaceNotFound = 26
+
+// IndexSpec lists the indexes each collection the API reads from must have
+func IndexSpec() map[string][]mongo.IndexModel {
+	return map[string][]mongo.IndexModel{
+		"audiobooks":  AudiobookIndexes(),
+		"suggestions": SuggestionIndexes(),
+	}
+}
+
+// IndexReport describes how a collection's indexes differ from the spec
+type IndexReport struct {
+	Collection string
+	Missing    []string
+	Changed    []string
+	Extra      []string
+}
+
+func (r IndexReport) OK() bool {
+	return len(r.Missing) == 0 && len(r.Changed) == 0
+}
+
+func indexName(model mongo.IndexModel) string {
+	if model.Options != nil && model.Options.Name != nil {
+		return *model.Options.Name
+	}
+	return ""
+}
+
+func asNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func sameValue(a, b interface{}) bool {
+	an, aok := asNumber(a)
+	bn, bok := asNumber(b)
+	if aok && bok {
+		return an == bn
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// textWeights gives the weight of every field of a text index, fields without a weight count 1
+func textWeights(model mongo.IndexModel) map[string]float64 {
+	weights := make(map[string]float64)
+	for _, k := range model.Keys.(bson.D) {
+		if k.Value == "text" {
+			weights[k.Key] = 1
+		}
+	}
+	if model.Options != nil {
+		if w, ok := model.Options.Weights.(bson.D); ok {
+			for _, e := range w {
+				weights[e.Key], _ = asNumber(e.Value)
+			}
+		}
+	}
+	return weights
+}
+
+func isTextIndex(model mongo.IndexModel) bool {
+	for _, k := range model.Keys.(bson.D) {
+		if k.Value == "text" {
+			return true
+		}
+	}
+	return false
+}
+
+// indexMatches compares an index as listed by Mongo with the spec
+func indexMatches(existing bson.M, model mongo.IndexModel) bool {
+	unique, _ := existing["unique"].(bool)
+	wantUnique := model.Options != nil && model.Options.Unique != nil && *model.Options.Unique
+	if unique != wantUnique {
+		return false
+	}
+
+	if isTextIndex(model) {
+		weights, _ := existing["weights"].(bson.M)
+		want := textWeights(model)
+		if len(weights) != len(want) {
+			return false
+		}
+		for field, w := range want {
+			if !sameValue(weights[field], w) {
+				return false
+			}
+		}
+		override, _ := existing["language_override"].(string)
+		if model.Options.LanguageOverride != nil && override != *model.Options.LanguageOverride {
+			return false
+		}
+		return true
+	}
+
+	keys, _ := existing["key"].(bson.D)
+	want := model.Keys.(bson.D)
+	if len(keys) != len(want) {
+		return false
+	}
+	for i := range want {
+		if keys[i].Key != want[i].Key || !sameValue(keys[i].Value, want[i].Value) {
+			return false
+		}
+	}
+	return true
+}
+
+func listIndexes(ctx context.Context, collection *mongo.Collection) (map[string]bson.M, error) {
+	cursor, err := collection.Indexes().List(ctx)
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Code == namespaceNotFound {
+		return map[string]bson.M{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var indexes []bson.Raw
+	if err := cursor.All(ctx, &indexes); err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]bson.M, len(indexes))
+	for _, raw := range indexes {
+		var index bson.M
+		if err := bson.Unmarshal(raw, &index); err != nil {
+			return nil, err
+		}
+		// key has to keep its field order to be compared
+		var keyed struct {
+			Key bson.D `bson:"key"`
+		}
+		if err := bson.Unmarshal(raw, &keyed); err != nil {
+			return nil, err
+		}
+		index["key"] = keyed.Key
+		name, _ := index["name"].(string)
+		byName[name] = index
+	}
+	return byName, nil
+}
+
+// CheckIndexes compares the indexes of one collection against the models it should have
+func CheckIndexes(ctx context.Context, collection *mongo.Collection, models []mongo.IndexModel) (IndexReport, error) {
+	report := IndexReport{Collection: collection.Name()}
+
+	existing, err := listIndexes(ctx, collection)
+	if err != nil {
+		return report, err
+	}
+
+	wanted := make(map[string]bool)
+	for _, model := range models {
+		name := indexName(model)
+		wanted[name] = true
+		index, ok := existing[name]
+		switch {
+		case !ok:
+			report.Missing = append(report.Missing, name)
+		case !indexMatches(index, model):
+			report.Changed = append(report.Changed, name)
+		}
+	}
+
+	for name := range existing {
+		if name != "_id_" && !wanted[name] {
+			report.Extra = append(report.Extra, name)
+		}
+	}
+
+	return report, nil
+}
+
+// VerifyIndexes checks every collection in IndexSpec and describes each problem it finds
+func VerifyIndexes(ctx context.Context, db *mongo.Database) ([]string, error) {
+	var problems []string
+	for collection, models := range IndexSpec() {
+		report, err := CheckIndexes(ctx, db.Collection(collection), models)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range report.Missing {
+			problems = append(problems, fmt.Sprintf("%s: index %s is missing", collection, name))
+		}
+		for _, name := range report.Changed {
+			problems = append(problems, fmt.Sprintf("%s: index %s differs from the spec", collection, name))
+		}
+	}
+	return problems, nil
+}
+
+// ReconcileIndexes creates missing indexes and recreates changed ones on collection,
+// indexes that aren't in the spec are dropped only when prune is set
+func ReconcileIndexes(ctx context.Context, collection *mongo.Collection, models []mongo.IndexModel, prune bool) (IndexReport, error) {
+	report, err := CheckIndexes(ctx, collection, models)
+	if err != nil {
+		return report, err
+	}
+
+	drop := append([]string{}, report.Changed...)
+	if prune {
+		drop = append(drop, report.Extra...)
+	} else if missingText(report, models) {
+		// a collection can only have one text index, so one made by hand has to go
+		existing, err := listIndexes(ctx, collection)
+		if err != nil {
+			return report, err
+		}
+		for _, name := range report.Extra {
+			if keys, _ := existing[name]["key"].(bson.D); len(keys) != 0 && keys[0].Key == "_fts" {
+				drop = append(drop, name)
+			}
+		}
+	}
+	for _, name := range drop {
+		if _, err := collection.Indexes().DropOne(ctx, name); err != nil {
+			return report, err
+		}
+	}
+
+	var create []mongo.IndexModel
+	for _, model := range models {
+		name := indexName(model)
+		for _, n := range append(append([]string{}, report.Missing...), report.Changed...) {
+			if n == name {
+				create = append(create, model)
+			}
+		}
+	}
+	if len(create) != 0 {
+		if _, err := collection.Indexes().CreateMany(ctx, create); err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+func missingText(report IndexReport, models []mongo.IndexModel) bool {
+	for _, model := range models {
+		if !isTextIndex(model) {
+			continue
+		}
+		for _, name := range report.Missing {
+			if name == indexName(model) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package repos
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestIndexMatches(t *testing.T) {
+	// 0 is id_unique, 1 text_search and 6 title_sort
+	spec := AudiobookIndexes()
+
+	tests := []struct {
+		name     string
+		existing bson.M
+		model    int
+		want     bool
+	}{
+		{"unique index", bson.M{"key": bson.D{{Key: "id", Value: int32(1)}}, "unique": true}, 0, true},
+		{"unique index that isn't unique", bson.M{"key": bson.D{{Key: "id", Value: int32(1)}}}, 0, false},
+		{"compound index", bson.M{"key": bson.D{{Key: "title", Value: 1.0}, {Key: "_id", Value: int64(1)}}}, 6, true},
+		{"compound index with its keys swapped", bson.M{"key": bson.D{{Key: "_id", Value: 1}, {Key: "title", Value: 1}}}, 6, false},
+		{"text index", bson.M{
+			"weights":           bson.M{"title": int32(10), "authors.first_name": int32(5), "authors.last_name": int32(5), "description": int32(1)},
+			"language_override": "text_language",
+		}, 1, true},
+		{"text index with other weights", bson.M{
+			"weights":           bson.M{"title": int32(1), "authors.first_name": int32(5), "authors.last_name": int32(5), "description": int32(1)},
+			"language_override": "text_language",
+		}, 1, false},
+		{"text index reading the language field", bson.M{
+			"weights":           bson.M{"title": int32(10), "authors.first_name": int32(5), "authors.last_name": int32(5), "description": int32(1)},
+			"language_override": "language",
+		}, 1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := indexMatches(tt.existing, spec[tt.model]); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
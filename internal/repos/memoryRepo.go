@@ -0,0 +1,721 @@
+package repos
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	Error "github.com/mayank12gt/free-audiobooks-backend/internal/errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryRepo keeps the whole catalog in memory and mirrors the filtering, sorting,
+// text search and pagination behaviour of AudiobooksRepo
+type MemoryRepo struct {
+	mu          sync.RWMutex
+	audiobooks  []*Audiobook
+	genres      []*GenreDTO
+	suggestions []*Suggestion
+	meta        *CatalogMeta
+}
+
+// MemoryData is the on-disk format accepted by LoadMemoryRepo
+type MemoryData struct {
+	Audiobooks []*Audiobook `json:"audiobooks"`
+	Genres     []*GenreDTO  `json:"genres"`
+	Meta       *CatalogMeta `json:"meta,omitempty"`
+}
+
+func NewMemoryRepo(audiobooks []*Audiobook, genres []*GenreDTO) *MemoryRepo {
+	for _, a := range audiobooks {
+		if a.ID.IsZero() {
+			a.ID = primitive.NewObjectID()
+		}
+	}
+
+	if len(genres) == 0 {
+		seen := make(map[string]bool)
+		for _, a := range audiobooks {
+			for _, g := range a.Genres {
+				if seen[g.ID] {
+					continue
+				}
+				seen[g.ID] = true
+				genres = append(genres, &GenreDTO{ID: primitive.NewObjectID(), IDStr: g.ID, Name: g.Name})
+			}
+		}
+	}
+
+	m := &MemoryRepo{
+		audiobooks: audiobooks,
+		genres:     genres,
+	}
+	m.suggestions = m.buildSuggestions()
+
+	return m
+}
+
+// buildSuggestions fills the typeahead index the same way AudiobooksRepo.RebuildSuggestions does
+func (m *MemoryRepo) buildSuggestions() []*Suggestion {
+	var suggestions []*Suggestion
+
+	genreCounts := make(map[string]int)
+	for _, a := range m.audiobooks {
+		suggestions = append(suggestions, newSuggestion(BookSuggestion, a.IDStr, a.Title, 1+a.Popularity))
+		for _, g := range a.Genres {
+			genreCounts[g.ID]++
+		}
+	}
+
+	for _, author := range m.authors() {
+		name := strings.TrimSpace(author.FirstName + " " + author.LastName)
+		suggestions = append(suggestions, newSuggestion(AuthorSuggestion, author.ID, name, author.BookCount))
+	}
+
+	for _, g := range m.genres {
+		suggestions = append(suggestions, newSuggestion(GenreSuggestion, g.IDStr, g.Name, genreCounts[g.IDStr]))
+	}
+
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		if suggestions[i].Weight != suggestions[j].Weight {
+			return suggestions[i].Weight > suggestions[j].Weight
+		}
+		return suggestions[i].Text < suggestions[j].Text
+	})
+
+	return suggestions
+}
+
+// LoadMemoryRepo reads a JSON file in the MemoryData format, an empty path gives an empty catalog
+func LoadMemoryRepo(path string) (*MemoryRepo, error) {
+	if path == "" {
+		return NewMemoryRepo(nil, nil), nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var data MemoryData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, err
+	}
+
+	m := NewMemoryRepo(data.Audiobooks, data.Genres)
+	m.meta = data.Meta
+	return m, nil
+}
+
+// textQuery is a parsed $text search string: plain terms are OR-ed, quoted phrases
+// are all required and terms prefixed with - exclude a document
+type textQuery struct {
+	terms    []string
+	phrases  []string
+	negated  []string
+	hasTerms bool
+}
+
+func parseTextQuery(search string) textQuery {
+	var q textQuery
+
+	for {
+		start := strings.Index(search, `"`)
+		if start == -1 {
+			break
+		}
+		end := strings.Index(search[start+1:], `"`)
+		if end == -1 {
+			break
+		}
+		phrase := strings.ToLower(strings.TrimSpace(search[start+1 : start+1+end]))
+		if phrase != "" {
+			q.phrases = append(q.phrases, phrase)
+			q.terms = append(q.terms, stemAll(tokenize(phrase))...)
+		}
+		search = search[:start] + " " + search[start+end+2:]
+	}
+
+	for _, word := range strings.Fields(search) {
+		if strings.HasPrefix(word, "-") {
+			q.negated = append(q.negated, stemAll(tokenize(word[1:]))...)
+			continue
+		}
+		q.terms = append(q.terms, stemAll(tokenize(word))...)
+	}
+
+	q.hasTerms = len(q.terms) != 0
+	return q
+}
+
+// stem strips a few common English suffixes so that plural and verb forms match
+func stem(word string) string {
+	for _, suffix := range []string{"ing", "es", "ed", "s"} {
+		if len(word)-len(suffix) >= 3 && strings.HasSuffix(word, suffix) {
+			return strings.TrimSuffix(word, suffix)
+		}
+	}
+	return word
+}
+
+func stemAll(words []string) []string {
+	stems := make([]string, 0, len(words))
+	for _, w := range words {
+		stems = append(stems, stem(w))
+	}
+	return stems
+}
+
+func searchableText(a *Audiobook) string {
+	var b strings.Builder
+	b.WriteString(a.Title)
+	b.WriteString(" ")
+	b.WriteString(a.Description)
+	for _, author := range a.Authors {
+		b.WriteString(" ")
+		b.WriteString(author.FirstName)
+		b.WriteString(" ")
+		b.WriteString(author.LastName)
+	}
+	return b.String()
+}
+
+func (q textQuery) matches(a *Audiobook) bool {
+	if !q.hasTerms {
+		return false
+	}
+
+	text := searchableText(a)
+	stems := make(map[string]bool)
+	for _, t := range stemAll(tokenize(text)) {
+		stems[t] = true
+	}
+
+	for _, n := range q.negated {
+		if stems[n] {
+			return false
+		}
+	}
+
+	lower := strings.ToLower(text)
+	for _, p := range q.phrases {
+		if !strings.Contains(lower, p) {
+			return false
+		}
+	}
+
+	for _, t := range q.terms {
+		if stems[t] {
+			return true
+		}
+	}
+	return false
+}
+
+// score approximates the Mongo text score, weighting title and author matches above the description
+func (q textQuery) score(a *Audiobook) float64 {
+	count := func(text string) float64 {
+		n := 0.0
+		for _, t := range stemAll(tokenize(text)) {
+			for _, term := range q.terms {
+				if t == term {
+					n++
+				}
+			}
+		}
+		return n
+	}
+
+	var authors strings.Builder
+	for _, author := range a.Authors {
+		authors.WriteString(author.FirstName + " " + author.LastName + " ")
+	}
+
+	return 10*count(a.Title) + 5*count(authors.String()) + count(a.Description)
+}
+
+func hasGenre(a *Audiobook, genres []string) bool {
+	for _, g := range a.Genres {
+		for _, id := range genres {
+			if g.ID == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// listView copies a book without the fields List leaves out of its projection
+func listView(a *Audiobook) *Audiobook {
+	c := *a
+	c.Sections = nil
+	c.Translators = nil
+	return &c
+}
+
+// memoryFilter evaluates a Filter against in-memory audiobooks
+type memoryFilter struct {
+	Filter
+	text textQuery
+}
+
+func newMemoryFilter(f Filter) memoryFilter {
+	mf := memoryFilter{Filter: f}
+	if f.Search != "" {
+		mf.text = parseTextQuery(f.Search)
+	}
+	return mf
+}
+
+// matches reports whether a passes every clause of the filter except the one named by exclude
+func (f memoryFilter) matches(a *Audiobook, exclude string) bool {
+	if f.Search != "" && !f.text.matches(a) {
+		return false
+	}
+	if len(f.Genres) != 0 && exclude != GenresFacet && !hasGenre(a, f.Genres) {
+		return false
+	}
+	if f.Language != "" && exclude != LanguageFacet && a.Language != f.Language {
+		return false
+	}
+	if f.hasLength() && exclude != LengthFacet {
+		if int64(a.TotalTimeSecs) < f.TotalTimeMin || int64(a.TotalTimeSecs) > f.TotalTimeMax {
+			return false
+		}
+	}
+	return true
+}
+
+// match returns list views of the books passing f, scored against the search if there is one.
+// The caller must hold the read lock
+func (m *MemoryRepo) match(f Filter) []*Audiobook {
+	filter := newMemoryFilter(f)
+
+	var matched []*Audiobook
+	for _, a := range m.audiobooks {
+		if !filter.matches(a, "") {
+			continue
+		}
+		view := listView(a)
+		if f.Search != "" {
+			view.Score = filter.text.score(a)
+		}
+		matched = append(matched, view)
+	}
+	return matched
+}
+
+func (m *MemoryRepo) List(ctx context.Context, f Filter, page, page_size int64, sortBy string) ([]*Audiobook, Metadata, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	matched := m.match(f)
+
+	keys := sortKeys(sortBy)
+	sort.SliceStable(matched, func(i, j int) bool {
+		return lessByKeys(matched[i], matched[j], keys)
+	})
+
+	audiobooks, meta := paginate(matched, page, page_size)
+
+	return audiobooks, meta, nil
+}
+
+func (m *MemoryRepo) ListCursor(ctx context.Context, f Filter, after string, page_size int64, sortBy string) ([]*Audiobook, Metadata, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	matched := m.match(f)
+
+	keys := sortKeys(sortBy)
+
+	var c *pageCursor
+	if after != "" {
+		var err error
+		c, err = decodeCursor(after, sortBy, keys)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+	}
+
+	fetchKeys := keys
+	if c != nil && c.Before {
+		fetchKeys = reversed(keys)
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return lessByKeys(matched[i], matched[j], fetchKeys)
+	})
+
+	var rows []*Audiobook
+	for _, a := range matched {
+		if int64(len(rows)) > page_size {
+			break
+		}
+		if c != nil && compareKeys(cursorValues(a, fetchKeys), c.Values, fetchKeys) <= 0 {
+			continue
+		}
+		rows = append(rows, a)
+	}
+
+	audiobooks, next, prev := cursorPage(rows, c, sortBy, keys, page_size)
+
+	return audiobooks, Metadata{
+		PageSize:     int(page_size),
+		TotalRecords: len(matched),
+		NextCursor:   next,
+		PrevCursor:   prev,
+	}, nil
+}
+
+func (m *MemoryRepo) Get(ctx context.Context, id string) (*Audiobook, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, a := range m.audiobooks {
+		if a.IDStr == id {
+			c := *a
+			return &c, nil
+		}
+	}
+
+	return nil, Error.NotFound("audiobook not found")
+}
+
+// RecordViews adds the view counts straight to popularity, no seeder replaces this catalog
+func (m *MemoryRepo) RecordViews(ctx context.Context, views map[string]int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, a := range m.audiobooks {
+		a.Popularity += int(views[a.IDStr])
+	}
+	return nil
+}
+
+func (m *MemoryRepo) GetGenres(ctx context.Context, page, page_size int64) ([]*GenreDTO, Metadata, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if len(m.genres) == 0 || page < 1 || page_size < 1 {
+		return []*GenreDTO{}, Metadata{}, nil
+	}
+
+	meta := calculateMetadata(len(m.genres), int(page), int(page_size))
+
+	start := (page - 1) * page_size
+	if start >= int64(len(m.genres)) {
+		return []*GenreDTO{}, meta, nil
+	}
+	end := start + page_size
+	if end > int64(len(m.genres)) {
+		end = int64(len(m.genres))
+	}
+
+	genres := make([]*GenreDTO, 0, end-start)
+	for _, g := range m.genres[start:end] {
+		c := *g
+		genres = append(genres, &c)
+	}
+
+	return genres, meta, nil
+}
+
+func (m *MemoryRepo) GetSimilar(ctx context.Context, id string, page, page_size int64) ([]*Audiobook, Metadata, error) {
+	book, err := m.Get(ctx, id)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	related := len(book.Genres) != 0 || len(book.Authors) != 0 || len(book.Translators) != 0
+
+	var candidates []*Audiobook
+	for _, a := range m.audiobooks {
+		if a.IDStr == book.IDStr {
+			continue
+		}
+		if related {
+			if sharedCount(genreIDs(book.Genres), genreIDs(a.Genres)) == 0 &&
+				sharedCount(authorIDs(book.Authors), authorIDs(a.Authors)) == 0 &&
+				sharedCount(translatorIDs(book.Translators), translatorIDs(a.Translators)) == 0 {
+				continue
+			}
+		} else if a.Language != book.Language {
+			continue
+		}
+		c := *a
+		c.Sections = nil
+		candidates = append(candidates, &c)
+	}
+
+	if len(candidates) == 0 {
+		return []*Audiobook{}, Metadata{}, nil
+	}
+
+	audiobooks, meta := paginate(rankSimilar(book, closestCandidates(book, candidates)), page, page_size)
+	return audiobooks, meta, nil
+}
+
+// authors groups the embedded authors of every audiobook, sorted like the Mongo aggregation
+func (m *MemoryRepo) authors() []*AuthorDTO {
+	byID := make(map[string]*AuthorDTO)
+	var authors []*AuthorDTO
+	for _, a := range m.audiobooks {
+		for _, author := range a.Authors {
+			dto, ok := byID[author.ID]
+			if !ok {
+				dto = &AuthorDTO{
+					ID:        author.ID,
+					FirstName: author.FirstName,
+					LastName:  author.LastName,
+					DOB:       author.DOB,
+					DOD:       author.DOD,
+					Lifespan:  lifespan(author.DOB, author.DOD),
+				}
+				byID[author.ID] = dto
+				authors = append(authors, dto)
+			}
+			dto.BookCount++
+		}
+	}
+
+	sort.SliceStable(authors, func(i, j int) bool {
+		if authors[i].LastName != authors[j].LastName {
+			return authors[i].LastName < authors[j].LastName
+		}
+		if authors[i].FirstName != authors[j].FirstName {
+			return authors[i].FirstName < authors[j].FirstName
+		}
+		return authors[i].ID < authors[j].ID
+	})
+
+	return authors
+}
+
+func (m *MemoryRepo) ListAuthors(ctx context.Context, search string, page, page_size int64) ([]*AuthorDTO, Metadata, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	search = strings.ToLower(search)
+
+	var matched []*AuthorDTO
+	for _, a := range m.authors() {
+		if search != "" && !strings.Contains(strings.ToLower(a.FirstName+" "+a.LastName), search) {
+			continue
+		}
+		matched = append(matched, a)
+	}
+
+	if len(matched) == 0 || page < 1 || page_size < 1 {
+		return []*AuthorDTO{}, Metadata{}, nil
+	}
+
+	meta := calculateMetadata(len(matched), int(page), int(page_size))
+
+	start := (page - 1) * page_size
+	if start >= int64(len(matched)) {
+		return []*AuthorDTO{}, meta, nil
+	}
+	end := start + page_size
+	if end > int64(len(matched)) {
+		end = int64(len(matched))
+	}
+
+	return matched[start:end], meta, nil
+}
+
+func (m *MemoryRepo) GetAuthor(ctx context.Context, id string) (*AuthorDTO, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, a := range m.authors() {
+		if a.ID == id {
+			return a, nil
+		}
+	}
+
+	return nil, Error.NotFound("author not found")
+}
+
+func (m *MemoryRepo) ListByAuthor(ctx context.Context, id string, page, page_size int64) ([]*Audiobook, Metadata, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var matched []*Audiobook
+	for _, a := range m.audiobooks {
+		if sharedCount(authorIDs(a.Authors), []string{id}) != 0 {
+			matched = append(matched, a)
+		}
+	}
+
+	if len(matched) == 0 {
+		return []*Audiobook{}, Metadata{}, nil
+	}
+
+	keys := sortKeys("title")
+	sort.SliceStable(matched, func(i, j int) bool {
+		return lessByKeys(matched[i], matched[j], keys)
+	})
+
+	pageItems, meta := paginate(matched, page, page_size)
+
+	audiobooks := make([]*Audiobook, 0, len(pageItems))
+	for _, a := range pageItems {
+		audiobooks = append(audiobooks, listView(a))
+	}
+
+	return audiobooks, meta, nil
+}
+
+func (m *MemoryRepo) Facets(ctx context.Context, f Filter, facets []string) (*Facets, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	filter := newMemoryFilter(f)
+	result := &Facets{}
+
+	for _, facet := range facets {
+		switch facet {
+		case GenresFacet:
+			byID := make(map[string]*FacetCount)
+			counts := []*FacetCount{}
+			for _, a := range m.audiobooks {
+				if !filter.matches(a, facet) {
+					continue
+				}
+				for _, g := range a.Genres {
+					fc, ok := byID[g.ID]
+					if !ok {
+						fc = &FacetCount{Value: g.ID, Name: g.Name}
+						byID[g.ID] = fc
+						counts = append(counts, fc)
+					}
+					fc.Count++
+				}
+			}
+			result.Genres = sortFacetCounts(counts)
+			if len(result.Genres) > maxGenreFacets {
+				result.Genres = result.Genres[:maxGenreFacets]
+			}
+		case LanguageFacet:
+			byLanguage := make(map[string]*FacetCount)
+			counts := []*FacetCount{}
+			for _, a := range m.audiobooks {
+				if !filter.matches(a, facet) {
+					continue
+				}
+				fc, ok := byLanguage[a.Language]
+				if !ok {
+					fc = &FacetCount{Value: a.Language}
+					byLanguage[a.Language] = fc
+					counts = append(counts, fc)
+				}
+				fc.Count++
+			}
+			result.Languages = sortFacetCounts(counts)
+		case LengthFacet:
+			counts := make(map[int64]int)
+			for _, a := range m.audiobooks {
+				if !filter.matches(a, facet) {
+					continue
+				}
+				for i := len(lengthBoundaries) - 1; i >= 0; i-- {
+					if int64(a.TotalTimeSecs) >= lengthBoundaries[i] {
+						counts[lengthBoundaries[i]]++
+						break
+					}
+				}
+			}
+			result.Length = lengthBucketsFromCounts(counts)
+		}
+	}
+
+	return result, nil
+}
+
+func (m *MemoryRepo) Stats(ctx context.Context) (*Stats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stats := &Stats{Books: int64(len(m.audiobooks)), Catalog: m.meta}
+
+	byLanguage := make(map[string]*FacetCount)
+	byGenre := make(map[string]*FacetCount)
+	languages, genres := []*FacetCount{}, []*FacetCount{}
+	var secs float64
+	for _, a := range m.audiobooks {
+		secs += float64(a.TotalTimeSecs)
+
+		fc, ok := byLanguage[a.Language]
+		if !ok {
+			fc = &FacetCount{Value: a.Language}
+			byLanguage[a.Language] = fc
+			languages = append(languages, fc)
+		}
+		fc.Count++
+
+		for _, g := range a.Genres {
+			fc, ok := byGenre[g.ID]
+			if !ok {
+				fc = &FacetCount{Value: g.ID, Name: g.Name}
+				byGenre[g.ID] = fc
+				genres = append(genres, fc)
+			}
+			fc.Count++
+		}
+	}
+
+	stats.TotalHours = hours(secs)
+	stats.Languages = sortFacetCounts(languages)
+	stats.Genres = sortFacetCounts(genres)
+	stats.Authors = int64(len(m.authors()))
+
+	return stats, nil
+}
+
+func (m *MemoryRepo) CatalogMeta(ctx context.Context) (*CatalogMeta, error) {
+	return m.meta, nil
+}
+
+func sortFacetCounts(counts []*FacetCount) []FacetCount {
+	sort.SliceStable(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Value < counts[j].Value
+	})
+
+	sorted := make([]FacetCount, 0, len(counts))
+	for _, fc := range counts {
+		sorted = append(sorted, *fc)
+	}
+	return sorted
+}
+
+func (m *MemoryRepo) Suggest(ctx context.Context, prefix string, limit int64) ([]*Suggestion, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	prefix = normalize(prefix)
+	suggestions := []*Suggestion{}
+	if prefix == "" {
+		return suggestions, nil
+	}
+
+	for _, s := range m.suggestions {
+		if int64(len(suggestions)) == limit {
+			break
+		}
+		if s.matchesPrefix(prefix) {
+			c := *s
+			suggestions = append(suggestions, &c)
+		}
+	}
+
+	return suggestions, nil
+}
@@ -0,0 +1,465 @@
+package repos
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+var (
+	austen   = Author{ID: "a1", FirstName: "Jane", LastName: "Austen", DOB: "1775", DOD: "1817"}
+	melville = Author{ID: "a2", FirstName: "Herman", LastName: "Melville", DOB: "1819", DOD: "1891"}
+	hugo     = Author{ID: "a3", FirstName: "Victor", LastName: "Hugo", DOB: "1802", DOD: "1885"}
+	homer    = Author{ID: "a4", LastName: "Homer"}
+
+	romance   = Genre{ID: "g1", Name: "Romance"}
+	adventure = Genre{ID: "g2", Name: "Adventure"}
+	poetry    = Genre{ID: "g3", Name: "Poetry"}
+)
+
+// testCatalog is a small catalog covering every length bucket, two languages and an author
+// with a single name. Books are added in id order, so date_added follows the ids
+func testCatalog() []*Audiobook {
+	return []*Audiobook{
+		{IDStr: "1", Title: "Pride and Prejudice", Language: "English", TotalTimeSecs: 40000, Popularity: 5,
+			Description: "A novel about manners and marriage in the country", Authors: []Author{austen}, Genres: []Genre{romance}},
+		{IDStr: "2", Title: "Emma", Language: "English", TotalTimeSecs: 30000, Popularity: 9,
+			Description: "A young woman meddles in the marriages of her friends", Authors: []Author{austen}, Genres: []Genre{romance}},
+		{IDStr: "3", Title: "Sense and Sensibility", Language: "English", TotalTimeSecs: 3000, Popularity: 5,
+			Description: "Two sisters and their romances", Authors: []Author{austen}, Genres: []Genre{romance}},
+		{IDStr: "4", Title: "Moby Dick", Language: "English", TotalTimeSecs: 90000, Popularity: 2,
+			Description: "The hunting of a white whale", Authors: []Author{melville}, Genres: []Genre{adventure}},
+		{IDStr: "5", Title: "Les Misérables", Language: "French", TotalTimeSecs: 200000,
+			Description: "Un roman sur la justice", Authors: []Author{hugo}, Genres: []Genre{adventure}},
+		{IDStr: "6", Title: "The Odyssey", Language: "English", TotalTimeSecs: 10800, Popularity: 1,
+			Description: "An epic poem about a hero who hunts his way home", Authors: []Author{homer}, Genres: []Genre{poetry, adventure}},
+	}
+}
+
+func testRepo() *MemoryRepo {
+	return NewMemoryRepo(testCatalog(), nil)
+}
+
+func TestMemoryRepoList(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter Filter
+		sortBy string
+		page   int64
+		size   int64
+		want   []string
+		total  int
+	}{
+		{"default sort is date added", Filter{}, "", 1, 10, []string{"1", "2", "3", "4", "5", "6"}, 6},
+		{"second page", Filter{}, "", 2, 4, []string{"5", "6"}, 6},
+		{"past the last page", Filter{}, "", 3, 4, []string{}, 6},
+		{"title", Filter{}, "title", 1, 3, []string{"2", "5", "4"}, 6},
+		{"descending with a tie break", Filter{}, "-popularity,title", 1, 4, []string{"2", "1", "3", "4"}, 6},
+		{"newest first", Filter{}, "-date_added", 1, 2, []string{"6", "5"}, 6},
+		{"language", Filter{Language: "French"}, "", 1, 10, []string{"5"}, 1},
+		{"genres match any", Filter{Genres: []string{"g3", "g1"}}, "", 1, 10, []string{"1", "2", "3", "6"}, 4},
+		{"length range", Filter{TotalTimeMin: 10000, TotalTimeMax: 40000}, "totaltimesecs", 1, 10, []string{"6", "2", "1"}, 3},
+		{"search stems plurals and verb forms", Filter{Search: "hunt"}, "", 1, 10, []string{"4", "6"}, 2},
+		{"search ranks title matches first", Filter{Search: "emma marriage"}, "relevance", 1, 10, []string{"2", "1"}, 2},
+		{"quoted phrase is required", Filter{Search: `"white whale"`}, "", 1, 10, []string{"4"}, 1},
+		{"negated term excludes", Filter{Search: "hunts -whale"}, "", 1, 10, []string{"6"}, 1},
+		{"page below one", Filter{}, "", 0, 10, []string{}, 0},
+		{"page size below one", Filter{}, "", 1, 0, []string{}, 0},
+		{"nothing matches", Filter{Search: "nothing"}, "", 1, 10, []string{}, 0},
+	}
+
+	repo := testRepo()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			audiobooks, meta, err := repo.List(context.Background(), tt.filter, tt.page, tt.size, tt.sortBy)
+			if err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			if got := ids(audiobooks); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ids = %v, want %v", got, tt.want)
+			}
+			if meta.TotalRecords != tt.total {
+				t.Errorf("total_records = %d, want %d", meta.TotalRecords, tt.total)
+			}
+		})
+	}
+}
+
+// TestMemoryRepoListCursor walks every sort forwards with next cursors and back with prev
+// cursors, both walks have to see the same books in the same order as List
+func TestMemoryRepoListCursor(t *testing.T) {
+	sorts := []string{"", "title", "-totaltimesecs", "popularity", "-popularity,title", "-date_added", "copyright_year"}
+
+	repo := testRepo()
+	for _, sortBy := range sorts {
+		t.Run("sort_by="+sortBy, func(t *testing.T) {
+			all, _, err := repo.List(context.Background(), Filter{}, 1, 100, sortBy)
+			if err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			want := ids(all)
+
+			var pages [][]*Audiobook
+			var prevs []string
+			after := ""
+			for {
+				page, meta, err := repo.ListCursor(context.Background(), Filter{}, after, 4, sortBy)
+				if err != nil {
+					t.Fatalf("ListCursor: %v", err)
+				}
+				if meta.TotalRecords != len(want) {
+					t.Errorf("total_records = %d, want %d", meta.TotalRecords, len(want))
+				}
+				pages = append(pages, page)
+				prevs = append(prevs, meta.PrevCursor)
+				if meta.NextCursor == "" {
+					break
+				}
+				if len(pages) > len(want) {
+					t.Fatal("next cursors never ran out")
+				}
+				after = meta.NextCursor
+			}
+
+			var forward []string
+			for _, page := range pages {
+				forward = append(forward, ids(page)...)
+			}
+			if !reflect.DeepEqual(forward, want) {
+				t.Fatalf("forward walk = %v, want %v", forward, want)
+			}
+
+			if prevs[0] != "" {
+				t.Error("the first page has a prev cursor")
+			}
+			for i := 1; i < len(pages); i++ {
+				page, _, err := repo.ListCursor(context.Background(), Filter{}, prevs[i], 4, sortBy)
+				if err != nil {
+					t.Fatalf("ListCursor: %v", err)
+				}
+				if got, want := ids(page), ids(pages[i-1]); !reflect.DeepEqual(got, want) {
+					t.Errorf("prev cursor of page %d = %v, want %v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryRepoListCursorRejects(t *testing.T) {
+	repo := testRepo()
+	_, meta, err := repo.ListCursor(context.Background(), Filter{}, "", 2, "title")
+	if err != nil {
+		t.Fatalf("ListCursor: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		after  string
+		sortBy string
+	}{
+		{"not base64", "%%%", "title"},
+		{"not a cursor", "aGVsbG8", "title"},
+		{"issued for another sort", meta.NextCursor, "-title"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := repo.ListCursor(context.Background(), Filter{}, tt.after, 2, tt.sortBy); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestMemoryRepoListLeavesOutSections(t *testing.T) {
+	repo := NewMemoryRepo([]*Audiobook{{IDStr: "1", Sections: []Section{{ID: "s1"}}, Translators: []Translator{{ID: "t1"}}}}, nil)
+
+	audiobooks, _, err := repo.List(context.Background(), Filter{}, 1, 10, "")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if audiobooks[0].Sections != nil || audiobooks[0].Translators != nil {
+		t.Errorf("list view kept sections or translators: %+v", audiobooks[0])
+	}
+
+	book, err := repo.Get(context.Background(), "1")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if len(book.Sections) != 1 {
+		t.Errorf("Get dropped the sections")
+	}
+}
+
+func TestMemoryRepoGetGenres(t *testing.T) {
+	tests := []struct {
+		name string
+		page int64
+		size int64
+		want []string
+	}{
+		{"first page", 1, 2, []string{"g1", "g2"}},
+		{"last partial page", 2, 2, []string{"g3"}},
+		{"past the end", 5, 2, []string{}},
+	}
+
+	repo := testRepo()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			genres, meta, err := repo.GetGenres(context.Background(), tt.page, tt.size)
+			if err != nil {
+				t.Fatalf("GetGenres: %v", err)
+			}
+			got := []string{}
+			for _, g := range genres {
+				got = append(got, g.IDStr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if meta.TotalRecords != 3 {
+				t.Errorf("total_records = %d, want 3", meta.TotalRecords)
+			}
+		})
+	}
+}
+
+func TestMemoryRepoPagination(t *testing.T) {
+	tests := []struct {
+		name  string
+		page  int64
+		size  int64
+		count int
+	}{
+		{"first page", 1, 2, 2},
+		{"last partial page", 2, 2, 1},
+		{"past the end", 5, 2, 0},
+		{"page zero", 0, 2, 0},
+		{"negative page", -1, 2, 0},
+		{"page size zero", 1, 0, 0},
+		{"negative page size", 1, -3, 0},
+	}
+
+	repo := testRepo()
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			genres, _, err := repo.GetGenres(ctx, tt.page, tt.size)
+			if err != nil {
+				t.Fatalf("GetGenres: %v", err)
+			}
+			if len(genres) != tt.count {
+				t.Errorf("GetGenres gave %d genres, want %d", len(genres), tt.count)
+			}
+
+			authors, _, err := repo.ListAuthors(ctx, "", tt.page, tt.size)
+			if err != nil {
+				t.Fatalf("ListAuthors: %v", err)
+			}
+			if (tt.page < 1 || tt.size < 1) && len(authors) != 0 {
+				t.Errorf("ListAuthors gave %d authors, want none", len(authors))
+			}
+
+			if _, _, err := repo.GetSimilar(ctx, "1", tt.page, tt.size); err != nil {
+				t.Fatalf("GetSimilar: %v", err)
+			}
+			if _, _, err := repo.ListByAuthor(ctx, "a1", tt.page, tt.size); err != nil {
+				t.Fatalf("ListByAuthor: %v", err)
+			}
+		})
+	}
+}
+
+func TestMemoryRepoFacets(t *testing.T) {
+	repo := testRepo()
+	facets, err := repo.Facets(context.Background(), Filter{Language: "English", Genres: []string{"g1"}}, []string{GenresFacet, LanguageFacet, LengthFacet})
+	if err != nil {
+		t.Fatalf("Facets: %v", err)
+	}
+
+	// each facet leaves its own filter out, so genres counts every English book and
+	// languages every romance
+	wantGenres := []FacetCount{
+		{Value: "g1", Name: "Romance", Count: 3},
+		{Value: "g2", Name: "Adventure", Count: 2},
+		{Value: "g3", Name: "Poetry", Count: 1},
+	}
+	if !reflect.DeepEqual(facets.Genres, wantGenres) {
+		t.Errorf("genres = %+v, want %+v", facets.Genres, wantGenres)
+	}
+	wantLanguages := []FacetCount{{Value: "English", Count: 3}}
+	if !reflect.DeepEqual(facets.Languages, wantLanguages) {
+		t.Errorf("languages = %+v, want %+v", facets.Languages, wantLanguages)
+	}
+	wantLength := []LengthBucket{
+		{Min: 0, Max: 60, Count: 1},
+		{Min: 300, Max: 600, Count: 1},
+		{Min: 600, Max: 1200, Count: 1},
+	}
+	if !reflect.DeepEqual(facets.Length, wantLength) {
+		t.Errorf("length = %+v, want %+v", facets.Length, wantLength)
+	}
+}
+
+func TestLengthBucketsFromCounts(t *testing.T) {
+	tests := []struct {
+		name   string
+		counts map[int64]int
+		want   []LengthBucket
+	}{
+		{"empty", map[int64]int{}, []LengthBucket{}},
+		{"empty buckets are left out", map[int64]int{3600: 2, 10 * 3600: 1}, []LengthBucket{{Min: 60, Max: 180, Count: 2}, {Min: 600, Max: 1200, Count: 1}}},
+		{"last bucket is open ended", map[int64]int{20 * 3600: 4}, []LengthBucket{{Min: 1200, Count: 4}}},
+		{"unknown bounds are ignored", map[int64]int{42: 7}, []LengthBucket{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lengthBucketsFromCounts(tt.counts); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryRepoGetSimilar(t *testing.T) {
+	repo := testRepo()
+	similar, meta, err := repo.GetSimilar(context.Background(), "1", 1, 10)
+	if err != nil {
+		t.Fatalf("GetSimilar: %v", err)
+	}
+	// same author and genre come first, then the books sharing nothing but the language are
+	// left out because the book has related books at all
+	if got, want := ids(similar), []string{"2", "3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if meta.TotalRecords != 2 {
+		t.Errorf("total_records = %d, want 2", meta.TotalRecords)
+	}
+
+	if _, _, err := repo.GetSimilar(context.Background(), "missing", 1, 10); err == nil {
+		t.Error("expected not found for a missing book")
+	}
+}
+
+func TestMemoryRepoListAuthors(t *testing.T) {
+	tests := []struct {
+		search string
+		want   []string
+	}{
+		{"", []string{"a1", "a4", "a3", "a2"}},
+		{"aus", []string{"a1"}},
+		{"JANE A", []string{"a1"}},
+		{"homer", []string{"a4"}},
+		{"nobody", []string{}},
+	}
+
+	repo := testRepo()
+	for _, tt := range tests {
+		t.Run(tt.search, func(t *testing.T) {
+			authors, _, err := repo.ListAuthors(context.Background(), tt.search, 1, 10)
+			if err != nil {
+				t.Fatalf("ListAuthors: %v", err)
+			}
+			got := []string{}
+			for _, a := range authors {
+				got = append(got, a.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryRepoGetAuthor(t *testing.T) {
+	repo := testRepo()
+	author, err := repo.GetAuthor(context.Background(), "a1")
+	if err != nil {
+		t.Fatalf("GetAuthor: %v", err)
+	}
+	if author.BookCount != 3 || author.Lifespan != "1775-1817" {
+		t.Errorf("author = %+v, want 3 books and lifespan 1775-1817", author)
+	}
+	if _, err := repo.GetAuthor(context.Background(), "missing"); err == nil {
+		t.Error("expected not found for a missing author")
+	}
+
+	books, meta, err := repo.ListByAuthor(context.Background(), "a1", 1, 2)
+	if err != nil {
+		t.Fatalf("ListByAuthor: %v", err)
+	}
+	// sorted by title
+	if got, want := ids(books), []string{"2", "1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if meta.TotalRecords != 3 {
+		t.Errorf("total_records = %d, want 3", meta.TotalRecords)
+	}
+}
+
+func TestMemoryRepoRecordViews(t *testing.T) {
+	repo := testRepo()
+	ctx := context.Background()
+	if err := repo.RecordViews(ctx, map[string]int64{"4": 10, "missing": 3}); err != nil {
+		t.Fatalf("RecordViews: %v", err)
+	}
+
+	book, err := repo.Get(ctx, "4")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if book.Popularity != 12 {
+		t.Errorf("popularity = %d, want 12", book.Popularity)
+	}
+}
+
+func TestMemoryRepoSuggest(t *testing.T) {
+	tests := []struct {
+		prefix string
+		limit  int64
+		want   []string
+	}{
+		{"aus", 10, []string{"Jane Austen"}},
+		{"ADV", 10, []string{"Adventure"}},
+		{"dick", 10, []string{"Moby Dick"}},
+		{"m", 2, []string{"Moby Dick", "Herman Melville"}},
+		{"  ", 10, []string{}},
+	}
+
+	repo := testRepo()
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			suggestions, err := repo.Suggest(context.Background(), tt.prefix, tt.limit)
+			if err != nil {
+				t.Fatalf("Suggest: %v", err)
+			}
+			got := []string{}
+			for _, s := range suggestions {
+				got = append(got, s.Text)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryRepoStats(t *testing.T) {
+	stats, err := testRepo().Stats(context.Background())
+	if err != nil {
+		t.Fatalf("Stats: %v", err)
+	}
+	want := &Stats{
+		Books:      6,
+		Authors:    4,
+		TotalHours: 103.8,
+		Languages:  []FacetCount{{Value: "English", Count: 5}, {Value: "French", Count: 1}},
+		Genres: []FacetCount{
+			{Value: "g1", Name: "Romance", Count: 3},
+			{Value: "g2", Name: "Adventure", Count: 3},
+			{Value: "g3", Name: "Poetry", Count: 1},
+		},
+	}
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("got %+v, want %+v", stats, want)
+	}
+}
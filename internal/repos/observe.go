@@ -0,0 +1,29 @@
+package repos
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mayank12gt/free-audiobooks-backend/internal/metrics"
+	"github.com/mayank12gt/free-audiobooks-backend/internal/tracing"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// observe times and traces one database call of a repo method, a lookup that finds nothing isn't
+// an error. For reads the returned func is called once the cursor is drained, so getMore round
+// trips and decoding are part of the call. Calls outside a traced request are only timed
+func observe(ctx context.Context, method, op string, attrs ...attribute.KeyValue) func(err error) {
+	done := metrics.ObserveDB(method, op)
+	_, span := tracing.StartChild(ctx, "AudiobooksRepo."+method+" "+op, append([]attribute.KeyValue{
+		attribute.String("db.system", "mongodb"),
+		attribute.String("db.operation", op),
+	}, attrs...)...)
+	return func(err error) {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			err = nil
+		}
+		done(err)
+		tracing.End(span, err)
+	}
+}
@@ -1,15 +1,25 @@
 package repos
 
-import "go.mongodb.org/mongo-driver/mongo"
+import "context"
 
-type Repos struct {
-	audiobooksRepo AudiobooksRepo
+// AudiobooksRepository is implemented by every storage backend the services can run against
+type AudiobooksRepository interface {
+	List(ctx context.Context, f Filter, page, page_size int64, sortBy string) ([]*Audiobook, Metadata, error)
+	ListCursor(ctx context.Context, f Filter, after string, page_size int64, sortBy string) ([]*Audiobook, Metadata, error)
+	Facets(ctx context.Context, f Filter, facets []string) (*Facets, error)
+	Get(ctx context.Context, id string) (*Audiobook, error)
+	RecordViews(ctx context.Context, views map[string]int64) error
+	GetGenres(ctx context.Context, page, page_size int64) ([]*GenreDTO, Metadata, error)
+	GetSimilar(ctx context.Context, id string, page, page_size int64) ([]*Audiobook, Metadata, error)
+	ListAuthors(ctx context.Context, search string, page, page_size int64) ([]*AuthorDTO, Metadata, error)
+	GetAuthor(ctx context.Context, id string) (*AuthorDTO, error)
+	ListByAuthor(ctx context.Context, id string, page, page_size int64) ([]*Audiobook, Metadata, error)
+	Suggest(ctx context.Context, prefix string, limit int64) ([]*Suggestion, error)
+	Stats(ctx context.Context) (*Stats, error)
+	CatalogMeta(ctx context.Context) (*CatalogMeta, error)
 }
 
-func NewRepos(db *mongo.Database) Repos {
-	return Repos{
-		audiobooksRepo: AudiobooksRepo{
-			DB: db,
-		},
-	}
-}
+var (
+	_ AudiobooksRepository = (*AudiobooksRepo)(nil)
+	_ AudiobooksRepository = (*MemoryRepo)(nil)
+)
@@ -0,0 +1,222 @@
+package repos
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// weights used when ranking similar books
+const (
+	genreWeight       = 3.0
+	authorWeight      = 4.0
+	translatorWeight  = 2.0
+	languageWeight    = 1.0
+	durationWeight    = 1.0
+	descriptionWeight = 2.0
+
+	similarCandidateLimit = 500
+)
+
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "by": true,
+	"for": true, "from": true, "has": true, "he": true, "her": true, "his": true, "in": true, "is": true,
+	"it": true, "its": true, "of": true, "on": true, "or": true, "she": true, "that": true, "the": true,
+	"their": true, "this": true, "to": true, "was": true, "were": true, "which": true, "with": true,
+	"who": true, "book": true, "librivox": true, "recording": true,
+}
+
+// tokenize lowercases text and splits it into words, dropping stop words and very short tokens
+func tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	tokens := make([]string, 0, len(words))
+	for _, w := range words {
+		if len(w) < 3 || stopWords[w] {
+			continue
+		}
+		tokens = append(tokens, w)
+	}
+	return tokens
+}
+
+func termFrequencies(text string) map[string]float64 {
+	tf := make(map[string]float64)
+	for _, t := range tokenize(text) {
+		tf[t]++
+	}
+	return tf
+}
+
+func cosine(a, b map[string]float64) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for term, wa := range a {
+		normA += wa * wa
+		if wb, ok := b[term]; ok {
+			dot += wa * wb
+		}
+	}
+	for _, wb := range b {
+		normB += wb * wb
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+func sharedCount(a, b []string) int {
+	set := make(map[string]bool, len(a))
+	for _, v := range a {
+		set[v] = true
+	}
+	n := 0
+	for _, v := range b {
+		if set[v] {
+			n++
+			delete(set, v)
+		}
+	}
+	return n
+}
+
+func genreIDs(genres []Genre) []string {
+	ids := make([]string, 0, len(genres))
+	for _, g := range genres {
+		ids = append(ids, g.ID)
+	}
+	return ids
+}
+
+func authorIDs(authors []Author) []string {
+	ids := make([]string, 0, len(authors))
+	for _, a := range authors {
+		ids = append(ids, a.ID)
+	}
+	return ids
+}
+
+func translatorIDs(translators []Translator) []string {
+	ids := make([]string, 0, len(translators))
+	for _, t := range translators {
+		ids = append(ids, t.ID)
+	}
+	return ids
+}
+
+// overlapScore is the part of similarity that only needs ids and the language. Candidates are
+// picked by it before the cap, so the cap keeps the closest books rather than whichever are
+// stored first. overlapExpr computes the same in MongoDB
+func overlapScore(book, candidate *Audiobook) float64 {
+	score := genreWeight * float64(sharedCount(genreIDs(book.Genres), genreIDs(candidate.Genres)))
+	score += authorWeight * float64(sharedCount(authorIDs(book.Authors), authorIDs(candidate.Authors)))
+	score += translatorWeight * float64(sharedCount(translatorIDs(book.Translators), translatorIDs(candidate.Translators)))
+
+	if book.Language != "" && book.Language == candidate.Language {
+		score += languageWeight
+	}
+	return score
+}
+
+// overlapExpr is overlapScore as an aggregation expression over the candidate document
+func overlapExpr(book *Audiobook) bson.M {
+	shared := func(field string, ids []string) bson.M {
+		return bson.M{"$size": bson.M{"$setIntersection": bson.A{bson.M{"$ifNull": bson.A{"$" + field, bson.A{}}}, ids}}}
+	}
+	terms := bson.A{
+		bson.M{"$multiply": bson.A{genreWeight, shared("genres.id", genreIDs(book.Genres))}},
+		bson.M{"$multiply": bson.A{authorWeight, shared("authors.id", authorIDs(book.Authors))}},
+		bson.M{"$multiply": bson.A{translatorWeight, shared("translators.id", translatorIDs(book.Translators))}},
+	}
+	if book.Language != "" {
+		terms = append(terms, bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$language", book.Language}}, languageWeight, 0}})
+	}
+	return bson.M{"$add": terms}
+}
+
+// closestCandidates keeps the similarCandidateLimit candidates with the highest overlapScore,
+// ties going to the book added first, the same ones the MongoDB pipeline keeps
+func closestCandidates(book *Audiobook, candidates []*Audiobook) []*Audiobook {
+	scores := make(map[*Audiobook]float64, len(candidates))
+	for _, c := range candidates {
+		scores[c] = overlapScore(book, c)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if scores[candidates[i]] == scores[candidates[j]] {
+			return candidates[i].ID.Hex() < candidates[j].ID.Hex()
+		}
+		return scores[candidates[i]] > scores[candidates[j]]
+	})
+	if len(candidates) > similarCandidateLimit {
+		candidates = candidates[:similarCandidateLimit]
+	}
+	return candidates
+}
+
+// similarity scores how close candidate is to book, higher is more similar
+func similarity(book *Audiobook, bookTerms map[string]float64, candidate *Audiobook) float64 {
+	score := overlapScore(book, candidate)
+
+	if book.TotalTimeSecs > 0 && candidate.TotalTimeSecs > 0 {
+		diff := math.Abs(float64(book.TotalTimeSecs - candidate.TotalTimeSecs))
+		longest := math.Max(float64(book.TotalTimeSecs), float64(candidate.TotalTimeSecs))
+		score += durationWeight * (1 - diff/longest)
+	}
+
+	score += descriptionWeight * cosine(bookTerms, termFrequencies(candidate.Description))
+
+	return score
+}
+
+// rankSimilar orders candidates by their similarity to book, dropping the book itself
+func rankSimilar(book *Audiobook, candidates []*Audiobook) []*Audiobook {
+	bookTerms := termFrequencies(book.Description)
+
+	type scored struct {
+		audiobook *Audiobook
+		score     float64
+	}
+	ranked := make([]scored, 0, len(candidates))
+	for _, c := range candidates {
+		if c.IDStr == book.IDStr {
+			continue
+		}
+		ranked = append(ranked, scored{audiobook: c, score: similarity(book, bookTerms, c)})
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].score == ranked[j].score {
+			return ranked[i].audiobook.IDStr < ranked[j].audiobook.IDStr
+		}
+		return ranked[i].score > ranked[j].score
+	})
+
+	audiobooks := make([]*Audiobook, 0, len(ranked))
+	for _, r := range ranked {
+		audiobooks = append(audiobooks, r.audiobook)
+	}
+	return audiobooks
+}
+
+// paginate returns the requested page of audiobooks along with its metadata
+func paginate(audiobooks []*Audiobook, page, page_size int64) ([]*Audiobook, Metadata) {
+	if page < 1 || page_size < 1 {
+		return []*Audiobook{}, Metadata{}
+	}
+	meta := calculateMetadata(len(audiobooks), int(page), int(page_size))
+
+	start := (page - 1) * page_size
+	if start >= int64(len(audiobooks)) {
+		return []*Audiobook{}, meta
+	}
+	end := start + page_size
+	if end > int64(len(audiobooks)) {
+		end = int64(len(audiobooks))
+	}
+	return audiobooks[start:end], meta
+}
@@ -0,0 +1,124 @@
+package repos
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func ids(audiobooks []*Audiobook) []string {
+	result := []string{}
+	for _, a := range audiobooks {
+		result = append(result, a.IDStr)
+	}
+	return result
+}
+
+func TestRankSimilar(t *testing.T) {
+	book := &Audiobook{IDStr: "book", Language: "English", TotalTimeSecs: 3600,
+		Description: "A whale hunt across the sea",
+		Authors:     []Author{{ID: "a1"}}, Genres: []Genre{{ID: "g1"}}}
+
+	candidates := []*Audiobook{
+		book,
+		{IDStr: "language", Language: "English"},
+		{IDStr: "french", Language: "French"},
+		{IDStr: "genre", Genres: []Genre{{ID: "g1"}}},
+		{IDStr: "author", Authors: []Author{{ID: "a1"}}},
+		{IDStr: "description", Description: "The whale and the sea"},
+		{IDStr: "length", TotalTimeSecs: 3600},
+	}
+
+	// authors weigh most, then genres and the description, ties go by id
+	want := []string{"author", "genre", "description", "language", "length", "french"}
+	if got := ids(rankSimilar(book, candidates)); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	got := tokenize("The Hunting of the Snark: an Agony, in 8 Fits (LibriVox)")
+	want := []string{"hunting", "snark", "agony", "fits"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestClosestCandidates(t *testing.T) {
+	book := &Audiobook{IDStr: "book", Language: "English", Authors: []Author{austen}, Genres: []Genre{romance}}
+
+	// the related books are stored last, the cap must still keep them
+	var candidates []*Audiobook
+	for i := 0; i < similarCandidateLimit+100; i++ {
+		candidates = append(candidates, &Audiobook{ID: primitive.NewObjectID(), IDStr: "other" + strconv.Itoa(i), Language: "English"})
+	}
+	sameGenre := &Audiobook{ID: primitive.NewObjectID(), IDStr: "genre", Genres: []Genre{romance}}
+	sameAuthor := &Audiobook{ID: primitive.NewObjectID(), IDStr: "author", Authors: []Author{austen}}
+	both := &Audiobook{ID: primitive.NewObjectID(), IDStr: "both", Language: "English", Authors: []Author{austen}, Genres: []Genre{romance}}
+	candidates = append(candidates, sameGenre, sameAuthor, both)
+
+	closest := closestCandidates(book, candidates)
+	if len(closest) != similarCandidateLimit {
+		t.Fatalf("kept %d candidates, want %d", len(closest), similarCandidateLimit)
+	}
+	if got, want := ids(closest[:4]), []string{"both", "author", "genre", "other0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("closest = %v, want %v", got, want)
+	}
+}
+
+func TestOverlapScore(t *testing.T) {
+	book := &Audiobook{Language: "English", Authors: []Author{austen}, Genres: []Genre{romance, poetry}, Translators: []Translator{{ID: "t1"}}}
+
+	tests := []struct {
+		name      string
+		candidate *Audiobook
+		want      float64
+	}{
+		{"nothing shared", &Audiobook{Language: "French"}, 0},
+		{"language", &Audiobook{Language: "English"}, languageWeight},
+		{"two genres", &Audiobook{Genres: []Genre{poetry, romance, adventure}}, 2 * genreWeight},
+		{"author and translator", &Audiobook{Authors: []Author{austen, hugo}, Translators: []Translator{{ID: "t1"}}}, authorWeight + translatorWeight},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := overlapScore(book, tt.candidate); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	books := make([]*Audiobook, 5)
+	for i := range books {
+		books[i] = &Audiobook{IDStr: strconv.Itoa(i + 1)}
+	}
+
+	tests := []struct {
+		name     string
+		page     int64
+		pageSize int64
+		want     []string
+		meta     Metadata
+	}{
+		{"first page", 1, 2, []string{"1", "2"}, Metadata{CurrentPage: 1, PageSize: 2, FirstPage: 1, LastPage: 3, TotalRecords: 5}},
+		{"last partial page", 3, 2, []string{"5"}, Metadata{CurrentPage: 3, PageSize: 2, FirstPage: 1, LastPage: 3, TotalRecords: 5}},
+		{"past the end", 4, 2, []string{}, Metadata{CurrentPage: 4, PageSize: 2, FirstPage: 1, LastPage: 3, TotalRecords: 5}},
+		{"page zero", 0, 2, []string{}, Metadata{}},
+		{"page size zero", 1, 0, []string{}, Metadata{}},
+		{"negative page size", 1, -1, []string{}, Metadata{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, meta := paginate(books, tt.page, tt.pageSize)
+			if got := ids(page); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("page = %v, want %v", got, tt.want)
+			}
+			if meta != tt.meta {
+				t.Errorf("meta = %+v, want %+v", meta, tt.meta)
+			}
+		})
+	}
+}
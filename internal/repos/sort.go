@@ -0,0 +1,200 @@
+package repos
+
+import (
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+// SortFields maps the sort_by names clients may use to the fields they sort on
+var SortFields = map[string]string{
+	"title":          "title",
+	"totaltimesecs":  "totaltimesecs",
+	"copyright_year": "copyright_year",
+	"date_added":     "_id",
+	"relevance":      "score",
+	"popularity":     "popularity",
+}
+
+// RelevanceSort is the sort_by name for text search score, it always sorts best match first
+const RelevanceSort = "relevance"
+
+// SplitSort splits sort_by into its comma separated names, reporting a leading - as descending
+func SplitSort(sortBy string) ([]string, []bool) {
+	var names []string
+	var desc []bool
+	for _, part := range strings.Split(sortBy, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		names = append(names, strings.TrimPrefix(part, "-"))
+		desc = append(desc, strings.HasPrefix(part, "-"))
+	}
+	return names, desc
+}
+
+// sortKeys turns sort_by into the keys to sort on, always ending with _id so the order is total.
+// Unknown names and names after date_added are skipped, Query.Validate rejects them before they get here
+func sortKeys(sortBy string) []SortKey {
+	var keys []SortKey
+	hasID := false
+
+	names, desc := SplitSort(sortBy)
+	for i, name := range names {
+		field, ok := SortFields[name]
+		if !ok {
+			continue
+		}
+		key := SortKey{Field: field, Desc: desc[i]}
+		if name == RelevanceSort {
+			key.Desc = true
+		}
+		keys = append(keys, key)
+		if field == "_id" {
+			hasID = true
+			break
+		}
+	}
+
+	if !hasID {
+		keys = append(keys, SortKey{Field: "_id"})
+	}
+	return keys
+}
+
+func hasScore(keys []SortKey) bool {
+	for _, k := range keys {
+		if k.Field == "score" {
+			return true
+		}
+	}
+	return false
+}
+
+// sortDoc builds a Find sort, the text score can only be sorted on through $meta there
+func sortDoc(keys []SortKey) bson.D {
+	doc := bson.D{}
+	for _, k := range keys {
+		if k.Field == "score" {
+			doc = append(doc, bson.E{Key: "score", Value: bson.M{"$meta": "textScore"}})
+			continue
+		}
+		doc = append(doc, bson.E{Key: k.Field, Value: direction(k)})
+	}
+	return doc
+}
+
+// pipelineSortDoc builds a $sort stage for pipelines that already added the score as a field
+func pipelineSortDoc(keys []SortKey) bson.D {
+	doc := bson.D{}
+	for _, k := range keys {
+		doc = append(doc, bson.E{Key: k.Field, Value: direction(k)})
+	}
+	return doc
+}
+
+func direction(k SortKey) int {
+	if k.Desc {
+		return -1
+	}
+	return 1
+}
+
+// reversed flips the direction of every key, used to walk backwards from a cursor
+func reversed(keys []SortKey) []SortKey {
+	rev := make([]SortKey, len(keys))
+	for i, k := range keys {
+		rev[i] = SortKey{Field: k.Field, Desc: !k.Desc}
+	}
+	return rev
+}
+
+// sortValue returns the value of a sortable field, nil for fields that are not sortable
+func sortValue(a *Audiobook, field string) interface{} {
+	switch field {
+	case "_id":
+		return a.ID
+	case "title":
+		return a.Title
+	case "totaltimesecs":
+		return int64(a.TotalTimeSecs)
+	case "copyright_year":
+		return a.CopyrightYear
+	case "score":
+		return a.Score
+	case "popularity":
+		return int64(a.Popularity)
+	}
+	return nil
+}
+
+// compareValues orders two sort values the way Mongo would for the types we store
+func compareValues(a, b interface{}) int {
+	switch av := a.(type) {
+	case primitive.ObjectID:
+		if bv, ok := b.(primitive.ObjectID); ok {
+			return strings.Compare(av.Hex(), bv.Hex())
+		}
+	case string:
+		if bv, ok := b.(string); ok {
+			return strings.Compare(av, bv)
+		}
+	}
+
+	af, aNum := toFloat(a)
+	bf, bNum := toFloat(b)
+	switch {
+	case aNum && bNum && af < bf:
+		return -1
+	case aNum && bNum && af > bf:
+		return 1
+	case aNum && bNum:
+		return 0
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	return 0
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// compareKeys compares two rows of sort key values under the given directions
+func compareKeys(a, b bson.A, keys []SortKey) int {
+	for i, k := range keys {
+		c := compareValues(a[i], b[i])
+		if k.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func lessByKeys(a, b *Audiobook, keys []SortKey) bool {
+	return compareKeys(cursorValues(a, keys), cursorValues(b, keys), keys) < 0
+}
@@ -0,0 +1,39 @@
+package repos
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSortKeys(t *testing.T) {
+	tests := []struct {
+		sortBy string
+		want   []SortKey
+	}{
+		{"", []SortKey{{Field: "_id"}}},
+		{"title", []SortKey{{Field: "title"}, {Field: "_id"}}},
+		{"-totaltimesecs,title", []SortKey{{Field: "totaltimesecs", Desc: true}, {Field: "title"}, {Field: "_id"}}},
+		{" popularity , -copyright_year ", []SortKey{{Field: "popularity"}, {Field: "copyright_year", Desc: true}, {Field: "_id"}}},
+		{"relevance", []SortKey{{Field: "score", Desc: true}, {Field: "_id"}}},
+		{"-date_added", []SortKey{{Field: "_id", Desc: true}}},
+		{"title,date_added,popularity", []SortKey{{Field: "title"}, {Field: "_id"}}},
+		{"unknown,title", []SortKey{{Field: "title"}, {Field: "_id"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sortBy, func(t *testing.T) {
+			if got := sortKeys(tt.sortBy); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitSort(t *testing.T) {
+	names, desc := SplitSort("-title,,popularity, -date_added")
+	if want := []string{"title", "popularity", "date_added"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("names = %v, want %v", names, want)
+	}
+	if want := []bool{true, false, true}; !reflect.DeepEqual(desc, want) {
+		t.Errorf("desc = %v, want %v", desc, want)
+	}
+}
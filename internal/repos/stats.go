@@ -0,0 +1,130 @@
+package repos
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CatalogMeta is the summary the seeder writes to meta_data at the end of every run
+type CatalogMeta struct {
+	TotalRecords int64     `bson:"total_records" json:"total_records"`
+	LastUpdated  time.Time `bson:"last_updated" json:"last_updated"`
+	Inserted     int64     `bson:"inserted" json:"inserted"`
+	Updated      int64     `bson:"updated" json:"updated"`
+	Unchanged    int64     `bson:"unchanged" json:"unchanged"`
+	Repaired     int64     `bson:"repaired" json:"repaired"`
+	Unrepairable int64     `bson:"unrepairable" json:"unrepairable"`
+}
+
+// Stats describes the live catalog, Catalog is nil until the seeder has run
+type Stats struct {
+	Books      int64        `json:"books"`
+	Authors    int64        `json:"authors"`
+	TotalHours float64      `json:"total_hours"`
+	Languages  []FacetCount `json:"languages"`
+	Genres     []FacetCount `json:"genres"`
+	Catalog    *CatalogMeta `json:"catalog,omitempty"`
+}
+
+func (m *AudiobooksRepo) Stats(ctx context.Context) (*Stats, error) {
+
+	collection := m.DB.Collection("audiobooks")
+
+	timeout := m.Timeouts.Search
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	done := observe(ctx, "Stats", "aggregate")
+	cursor, err := collection.Aggregate(ctx, bson.A{
+		bson.M{"$facet": bson.M{
+			"totals": bson.A{
+				bson.M{"$group": bson.M{"_id": nil, "books": bson.M{"$sum": 1}, "secs": bson.M{"$sum": "$totaltimesecs"}}},
+			},
+			"languages": bson.A{
+				bson.M{"$group": bson.M{"_id": "$language", "count": bson.M{"$sum": 1}}},
+				bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+			},
+			"genres": bson.A{
+				bson.M{"$unwind": "$genres"},
+				bson.M{"$group": bson.M{"_id": "$genres.id", "name": bson.M{"$first": "$genres.name"}, "count": bson.M{"$sum": 1}}},
+				bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+			},
+			"authors": bson.A{
+				bson.M{"$unwind": "$authors"},
+				bson.M{"$group": bson.M{"_id": "$authors.id"}},
+				bson.M{"$count": "count"},
+			},
+		}},
+	}, options.Aggregate().SetMaxTime(timeout))
+	if err != nil {
+		done(err)
+		return nil, dbError(ctx, err)
+	}
+
+	var result []struct {
+		Totals []struct {
+			Books int64   `bson:"books"`
+			Secs  float64 `bson:"secs"`
+		} `bson:"totals"`
+		Languages []FacetCount `bson:"languages"`
+		Genres    []FacetCount `bson:"genres"`
+		Authors   []struct {
+			Count int64 `bson:"count"`
+		} `bson:"authors"`
+	}
+	err = cursor.All(ctx, &result)
+	done(err)
+	if err != nil {
+		return nil, dbError(ctx, err)
+	}
+
+	stats := &Stats{Languages: []FacetCount{}, Genres: []FacetCount{}}
+	if len(result) != 0 {
+		r := result[0]
+		if len(r.Totals) != 0 {
+			stats.Books = r.Totals[0].Books
+			stats.TotalHours = hours(r.Totals[0].Secs)
+		}
+		if len(r.Authors) != 0 {
+			stats.Authors = r.Authors[0].Count
+		}
+		stats.Languages = append(stats.Languages, r.Languages...)
+		stats.Genres = append(stats.Genres, r.Genres...)
+	}
+
+	stats.Catalog, err = m.CatalogMeta(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// CatalogMeta returns the summary of the latest seeder run, nil when the seeder never ran
+func (m *AudiobooksRepo) CatalogMeta(ctx context.Context) (*CatalogMeta, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	var meta CatalogMeta
+	done := observe(ctx, "CatalogMeta", "find_one")
+	err := m.DB.Collection("meta_data").FindOne(ctx, bson.D{},
+		options.FindOne().SetMaxTime(m.Timeouts.Query).SetSort(bson.D{{Key: "last_updated", Value: -1}})).Decode(&meta)
+	done(err)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, dbError(ctx, err)
+	}
+	return &meta, nil
+}
+
+// hours converts seconds to hours rounded to one decimal
+func hours(secs float64) float64 {
+	return float64(int64(secs/360+0.5)) / 10
+}
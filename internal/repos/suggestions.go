@@ -0,0 +1,236 @@
+package repos
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// suggestion types
+const (
+	BookSuggestion   = "book"
+	AuthorSuggestion = "author"
+	GenreSuggestion  = "genre"
+)
+
+// Suggestion is one entry of the typeahead index, Terms holds every word suffix of the
+// normalized text so that a prefix query can match from the start of any word
+type Suggestion struct {
+	Type   string   `bson:"type" json:"type"`
+	RefID  string   `bson:"ref_id" json:"id"`
+	Text   string   `bson:"text" json:"text"`
+	Terms  []string `bson:"terms" json:"-"`
+	Weight int      `bson:"weight" json:"-"`
+}
+
+const maxSuggestionTerms = 8
+
+// normalize lowercases text and collapses everything but letters and digits into single spaces
+func normalize(text string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}), " ")
+}
+
+func suggestionTerms(text string) []string {
+	words := strings.Fields(normalize(text))
+	var terms []string
+	for i := range words {
+		if i == maxSuggestionTerms {
+			break
+		}
+		terms = append(terms, strings.Join(words[i:], " "))
+	}
+	return terms
+}
+
+func newSuggestion(kind, refID, text string, weight int) *Suggestion {
+	return &Suggestion{
+		Type:   kind,
+		RefID:  refID,
+		Text:   text,
+		Terms:  suggestionTerms(text),
+		Weight: weight,
+	}
+}
+
+// matchesPrefix reports whether any word suffix of the suggestion starts with the normalized prefix
+func (s *Suggestion) matchesPrefix(prefix string) bool {
+	for _, t := range s.Terms {
+		if strings.HasPrefix(t, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *AudiobooksRepo) Suggest(ctx context.Context, prefix string, limit int64) ([]*Suggestion, error) {
+
+	collection := m.DB.Collection("suggestions")
+
+	timeout := m.Timeouts.Query
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	prefix = normalize(prefix)
+	if prefix == "" {
+		return []*Suggestion{}, nil
+	}
+
+	filter := bson.D{{Key: "terms", Value: bson.M{"$regex": "^" + regexp.QuoteMeta(prefix)}}}
+	options := options.Find().
+		SetMaxTime(timeout).
+		SetProjection(bson.D{{Key: "terms", Value: 0}}).
+		SetSort(bson.D{{Key: "weight", Value: -1}, {Key: "text", Value: 1}}).
+		SetLimit(limit)
+
+	done := observe(ctx, "Suggest", "find")
+	cursor, err := collection.Find(ctx, filter, options)
+	if err != nil {
+		done(err)
+		return nil, dbError(ctx, err)
+	}
+	defer cursor.Close(ctx)
+
+	suggestions := []*Suggestion{}
+	err = cursor.All(ctx, &suggestions)
+	done(err)
+	if err != nil {
+		return nil, dbError(ctx, err)
+	}
+
+	return suggestions, nil
+}
+
+// RebuildSuggestions regenerates the suggestions collection from audiobooks and genres.
+// The new index is built in a scratch collection and renamed over the old one so readers never see it half built
+func (m *AudiobooksRepo) RebuildSuggestions(ctx context.Context) (int, error) {
+
+	scratch := m.DB.Collection("suggestions_build")
+	if err := scratch.Drop(ctx); err != nil {
+		return 0, err
+	}
+
+	var batch []interface{}
+	total := 0
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if _, err := scratch.InsertMany(ctx, batch); err != nil {
+			return err
+		}
+		total += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+	add := func(s *Suggestion) error {
+		if len(s.Terms) == 0 {
+			return nil
+		}
+		batch = append(batch, s)
+		if len(batch) == 1000 {
+			return flush()
+		}
+		return nil
+	}
+
+	books, err := m.DB.Collection("audiobooks").Find(ctx, bson.D{},
+		options.Find().SetProjection(bson.D{{Key: "id", Value: 1}, {Key: "title", Value: 1}, {Key: "popularity", Value: 1}}))
+	if err != nil {
+		return 0, err
+	}
+	defer books.Close(ctx)
+	for books.Next(ctx) {
+		var book Audiobook
+		if err := books.Decode(&book); err != nil {
+			return 0, err
+		}
+		if err := add(newSuggestion(BookSuggestion, book.IDStr, book.Title, 1+book.Popularity)); err != nil {
+			return 0, err
+		}
+	}
+	if err := books.Err(); err != nil {
+		return 0, err
+	}
+
+	authors, err := m.DB.Collection("audiobooks").Aggregate(ctx, authorsPipeline())
+	if err != nil {
+		return 0, err
+	}
+	defer authors.Close(ctx)
+	for authors.Next(ctx) {
+		var author AuthorDTO
+		if err := authors.Decode(&author); err != nil {
+			return 0, err
+		}
+		name := strings.TrimSpace(author.FirstName + " " + author.LastName)
+		if err := add(newSuggestion(AuthorSuggestion, author.ID, name, author.BookCount)); err != nil {
+			return 0, err
+		}
+	}
+	if err := authors.Err(); err != nil {
+		return 0, err
+	}
+
+	genres, err := m.DB.Collection("audiobooks").Aggregate(ctx, bson.A{
+		bson.M{"$unwind": "$genres"},
+		bson.M{"$group": bson.M{"_id": "$genres.id", "name": bson.M{"$first": "$genres.name"}, "count": bson.M{"$sum": 1}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	var genreCounts []FacetCount
+	if err := genres.All(ctx, &genreCounts); err != nil {
+		return 0, err
+	}
+	counted := make(map[string]bool)
+	for _, g := range genreCounts {
+		counted[g.Value] = true
+		if err := add(newSuggestion(GenreSuggestion, g.Value, g.Name, g.Count)); err != nil {
+			return 0, err
+		}
+	}
+
+	// genres that no book uses yet are still worth suggesting
+	var allGenres []*GenreDTO
+	cursor, err := m.DB.Collection("genres").Find(ctx, bson.D{})
+	if err != nil {
+		return 0, err
+	}
+	if err := cursor.All(ctx, &allGenres); err != nil {
+		return 0, err
+	}
+	for _, g := range allGenres {
+		if counted[g.IDStr] {
+			continue
+		}
+		if err := add(newSuggestion(GenreSuggestion, g.IDStr, g.Name, 0)); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := flush(); err != nil {
+		return 0, err
+	}
+
+	_, err = scratch.Indexes().CreateMany(ctx, SuggestionIndexes())
+	if err != nil {
+		return 0, err
+	}
+
+	err = m.DB.Client().Database("admin").RunCommand(ctx, bson.D{
+		{Key: "renameCollection", Value: m.DB.Name() + ".suggestions_build"},
+		{Key: "to", Value: m.DB.Name() + ".suggestions"},
+		{Key: "dropTarget", Value: true},
+	}).Err()
+	if err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
@@ -0,0 +1,29 @@
+package repos
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSuggestionTerms(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Moby Dick", []string{"moby dick", "dick"}},
+		{"  Les Misérables: Tome I ", []string{"les misérables tome i", "misérables tome i", "tome i", "i"}},
+		{"one two three four five six seven eight nine", []string{
+			"one two three four five six seven eight nine", "two three four five six seven eight nine",
+			"three four five six seven eight nine", "four five six seven eight nine", "five six seven eight nine",
+			"six seven eight nine", "seven eight nine", "eight nine",
+		}},
+		{"--", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got := suggestionTerms(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package repos
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	Error "github.com/mayank12gt/free-audiobooks-backend/internal/errors"
+	"github.com/mayank12gt/free-audiobooks-backend/internal/logging"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+// Timeouts bound a single repository operation. Search covers text search, facets and the
+// other aggregations, Query everything else
+type Timeouts struct {
+	Query  time.Duration
+	Search time.Duration
+}
+
+var DefaultTimeouts = Timeouts{
+	Query:  5 * time.Second,
+	Search: 10 * time.Second,
+}
+
+// searchTimeout picks the budget of a listing, text search gets the larger one
+func (t Timeouts) searchTimeout(f Filter) time.Duration {
+	if f.Search != "" {
+		return t.Search
+	}
+	return t.Query
+}
+
+// dbError turns a driver error into the error handed to the services. An unreachable database
+// answers 503 and a query that ran out of time 504, anything else stays an internal error.
+// The driver error is logged here since it doesn't reach the client
+func dbError(ctx context.Context, err error) error {
+	logger := logging.FromContext(ctx)
+	var selection topology.ServerSelectionError
+	switch {
+	case errors.Is(err, context.Canceled):
+		// the client went away, nobody reads the status but access logs shouldn't count it as ours
+		logger.Debug("database call canceled", "err", err)
+		return Error.Unavailable("request canceled").SetCode(499)
+	case errors.As(err, &selection):
+		logger.Warn("database unavailable", "err", err)
+		return Error.Unavailable("database unavailable")
+	case mongo.IsTimeout(err):
+		logger.Warn("database call timed out", "err", err)
+		return Error.Timeout("the query took too long")
+	}
+	logger.Error("database call failed", "err", err)
+	return Error.Internal("")
+}
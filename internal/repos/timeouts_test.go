@@ -0,0 +1,43 @@
+package repos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	Error "github.com/mayank12gt/free-audiobooks-backend/internal/errors"
+)
+
+func TestDBError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+	}{
+		{"canceled by the client", fmt.Errorf("find: %w", context.Canceled), 499},
+		{"out of time", fmt.Errorf("find: %w", context.DeadlineExceeded), 504},
+		{"anything else", errors.New("boom"), 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err *Error.Err
+			if !errors.As(dbError(context.Background(), tt.err), &err) {
+				t.Fatalf("dbError(%v) isn't an *Err", tt.err)
+			}
+			if err.Status() != tt.status {
+				t.Errorf("status = %d, want %d", err.Status(), tt.status)
+			}
+		})
+	}
+}
+
+func TestSearchTimeout(t *testing.T) {
+	timeouts := Timeouts{Query: 1, Search: 2}
+	if got := timeouts.searchTimeout(Filter{Language: "English"}); got != 1 {
+		t.Errorf("listing got %v, want the query timeout", got)
+	}
+	if got := timeouts.searchTimeout(Filter{Search: "whale"}); got != 2 {
+		t.Errorf("search got %v, want the search timeout", got)
+	}
+}
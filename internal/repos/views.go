@@ -0,0 +1,55 @@
+package repos
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ViewsCollection holds a view count per book id. It is kept apart from the books because the
+// seeder replaces the whole audiobooks collection, it folds the counts into popularity instead
+const ViewsCollection = "views"
+
+// RecordViews adds a batch of detail view counts, keyed by book id, to the views collection
+func (m *AudiobooksRepo) RecordViews(ctx context.Context, views map[string]int64) error {
+	if len(views) == 0 {
+		return nil
+	}
+
+	collection := m.DB.Collection(ViewsCollection)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.Query)
+	defer cancel()
+
+	models := make([]mongo.WriteModel, 0, len(views))
+	for id, count := range views {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{{Key: "_id", Value: id}}).
+			SetUpdate(bson.D{{Key: "$inc", Value: bson.D{{Key: "count", Value: count}}}}).
+			SetUpsert(true))
+	}
+
+	done := observe(ctx, "RecordViews", "bulk_write")
+	_, err := collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	done(err)
+	return err
+}
+
+// ApplyViews sets popularity on the books in collection to their count in the views collection.
+// Counts are matched on id, so collection needs its unique id index
+func ApplyViews(ctx context.Context, db *mongo.Database, collection string) error {
+	cursor, err := db.Collection(ViewsCollection).Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$project", Value: bson.D{{Key: "_id", Value: 0}, {Key: "id", Value: "$_id"}, {Key: "popularity", Value: "$count"}}}},
+		{{Key: "$merge", Value: bson.D{
+			{Key: "into", Value: collection},
+			{Key: "on", Value: "id"},
+			{Key: "whenMatched", Value: bson.A{bson.D{{Key: "$set", Value: bson.D{{Key: "popularity", Value: "$$new.popularity"}}}}}},
+			{Key: "whenNotMatched", Value: "discard"},
+		}}},
+	})
+	if err != nil {
+		return err
+	}
+	return cursor.Close(ctx)
+}
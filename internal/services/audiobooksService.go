@@ -1,11 +1,16 @@
 package services
 
 import (
+	"context"
+
 	"github.com/mayank12gt/free-audiobooks-backend/internal/repos"
+	"github.com/mayank12gt/free-audiobooks-backend/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type AudiobookService struct {
-	audiobookRepo repos.AudiobooksRepo
+	audiobookRepo repos.AudiobooksRepository
+	views         *ViewRecorder
 }
 
 type Query struct {
@@ -16,6 +21,9 @@ type Query struct {
 	PageSize       int
 	Page           int
 	Sort           string
+	Facets         []string
+	UseCursor      bool
+	Cursor         string
 }
 
 type TimeRange struct {
@@ -23,26 +31,87 @@ type TimeRange struct {
 	TotalTimeMax int64
 }
 
-func (s *AudiobookService) List(query Query) ([]*repos.Audiobook, repos.Metadata, error) {
+// attributes describe the shape of the query for tracing, leaving out the search text
+func (q Query) attributes() []attribute.KeyValue {
+	return append(q.filter().Attributes(),
+		attribute.String("sort_by", q.Sort),
+		attribute.Int("page", q.Page),
+		attribute.Int("page_size", q.PageSize),
+		attribute.Bool("cursor", q.UseCursor),
+		attribute.StringSlice("facets", q.Facets),
+	)
+}
+
+// filter converts the query into repo filters, lengths are given in minutes and stored in seconds
+func (q Query) filter() repos.Filter {
+	return repos.Filter{
+		Search:       q.Search,
+		Genres:       q.Genres,
+		Language:     q.Language,
+		TotalTimeMin: q.TotalTimeRange.TotalTimeMin * 60,
+		TotalTimeMax: q.TotalTimeRange.TotalTimeMax * 60,
+	}
+}
 
-	query.TotalTimeRange.TotalTimeMax = query.TotalTimeRange.TotalTimeMax * 60
-	query.TotalTimeRange.TotalTimeMin = query.TotalTimeRange.TotalTimeMin * 60
+func (s *AudiobookService) List(ctx context.Context, query Query) ([]*repos.Audiobook, repos.Metadata, error) {
+	ctx, span := tracing.StartChild(ctx, "AudiobookService.List", query.attributes()...)
+	defer span.End()
 
-	audiobooks, meta, err := s.audiobookRepo.List(query.Search, query.Genres, query.Language, query.TotalTimeRange.TotalTimeMin, query.TotalTimeRange.TotalTimeMax, int64(query.Page), int64(query.PageSize), query.Sort)
+	// best matches first unless the client asked for another order
+	if query.Search != "" && query.Sort == "" {
+		query.Sort = repos.RelevanceSort
+	}
+
+	var audiobooks []*repos.Audiobook
+	var meta repos.Metadata
+	var err error
+
+	if query.UseCursor {
+		audiobooks, meta, err = s.audiobookRepo.ListCursor(ctx, query.filter(), query.Cursor, int64(query.PageSize), query.Sort)
+	} else {
+		audiobooks, meta, err = s.audiobookRepo.List(ctx, query.filter(), int64(query.Page), int64(query.PageSize), query.Sort)
+	}
 	if err != nil {
 		return nil, meta, err
 	}
 
+	if query.Search != "" {
+		for _, a := range audiobooks {
+			a.Highlights = repos.Highlight(a, query.Search)
+		}
+	}
+
 	return audiobooks, meta, nil
 
 }
 
-func (s *AudiobookService) Get(id string) (*repos.Audiobook, error) {
-	return s.audiobookRepo.Get(id)
+// Facets counts matches per genre, language and length bucket, each facet ignoring its own filter
+func (s *AudiobookService) Facets(ctx context.Context, query Query) (*repos.Facets, error) {
+	ctx, span := tracing.StartChild(ctx, "AudiobookService.Facets", query.attributes()...)
+	defer span.End()
+	if len(query.Facets) == 0 {
+		return nil, nil
+	}
+	return s.audiobookRepo.Facets(ctx, query.filter(), query.Facets)
 }
 
-func (s *AudiobookService) GetGenres(page, page_size int) ([]*repos.GenreDTO, repos.Metadata, error) {
-	genres, meta, err := s.audiobookRepo.GetGenres(int64(page), int64(page_size))
+func (s *AudiobookService) Get(ctx context.Context, id string) (*repos.Audiobook, error) {
+	ctx, span := tracing.StartChild(ctx, "AudiobookService.Get")
+	defer span.End()
+	audiobook, err := s.audiobookRepo.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	s.views.Record(id)
+
+	return audiobook, nil
+}
+
+func (s *AudiobookService) GetGenres(ctx context.Context, page, page_size int) ([]*repos.GenreDTO, repos.Metadata, error) {
+	ctx, span := tracing.StartChild(ctx, "AudiobookService.GetGenres", attribute.Int("page", page), attribute.Int("page_size", page_size))
+	defer span.End()
+	genres, meta, err := s.audiobookRepo.GetGenres(ctx, int64(page), int64(page_size))
 	if err != nil {
 		return nil, meta, err
 	}
@@ -51,6 +120,58 @@ func (s *AudiobookService) GetGenres(page, page_size int) ([]*repos.GenreDTO, re
 
 }
 
-func (s *AudiobookService) GetSimilarBooks(id string) (*repos.Audiobook, error) {
-	return nil, nil
+func (s *AudiobookService) GetSimilarBooks(ctx context.Context, id string, page, page_size int) ([]*repos.Audiobook, repos.Metadata, error) {
+	ctx, span := tracing.StartChild(ctx, "AudiobookService.GetSimilarBooks", attribute.Int("page", page), attribute.Int("page_size", page_size))
+	defer span.End()
+	audiobooks, meta, err := s.audiobookRepo.GetSimilar(ctx, id, int64(page), int64(page_size))
+	if err != nil {
+		return nil, meta, err
+	}
+
+	return audiobooks, meta, nil
+}
+
+func (s *AudiobookService) ListAuthors(ctx context.Context, search string, page, page_size int) ([]*repos.AuthorDTO, repos.Metadata, error) {
+	ctx, span := tracing.StartChild(ctx, "AudiobookService.ListAuthors", attribute.Bool("filter.search", search != ""), attribute.Int("page", page), attribute.Int("page_size", page_size))
+	defer span.End()
+	authors, meta, err := s.audiobookRepo.ListAuthors(ctx, search, int64(page), int64(page_size))
+	if err != nil {
+		return nil, meta, err
+	}
+
+	return authors, meta, nil
+}
+
+func (s *AudiobookService) GetAuthor(ctx context.Context, id string, page, page_size int) (*repos.AuthorDTO, []*repos.Audiobook, repos.Metadata, error) {
+	ctx, span := tracing.StartChild(ctx, "AudiobookService.GetAuthor", attribute.Int("page", page), attribute.Int("page_size", page_size))
+	defer span.End()
+	author, err := s.audiobookRepo.GetAuthor(ctx, id)
+	if err != nil {
+		return nil, nil, repos.Metadata{}, err
+	}
+
+	audiobooks, meta, err := s.audiobookRepo.ListByAuthor(ctx, id, int64(page), int64(page_size))
+	if err != nil {
+		return nil, nil, meta, err
+	}
+
+	return author, audiobooks, meta, nil
+}
+
+func (s *AudiobookService) Suggest(ctx context.Context, prefix string, limit int) ([]*repos.Suggestion, error) {
+	ctx, span := tracing.StartChild(ctx, "AudiobookService.Suggest", attribute.Int("prefix_length", len(prefix)), attribute.Int("limit", limit))
+	defer span.End()
+	return s.audiobookRepo.Suggest(ctx, prefix, int64(limit))
+}
+
+func (s *AudiobookService) Stats(ctx context.Context) (*repos.Stats, error) {
+	ctx, span := tracing.StartChild(ctx, "AudiobookService.Stats")
+	defer span.End()
+	return s.audiobookRepo.Stats(ctx)
+}
+
+func (s *AudiobookService) CatalogMeta(ctx context.Context) (*repos.CatalogMeta, error) {
+	ctx, span := tracing.StartChild(ctx, "AudiobookService.CatalogMeta")
+	defer span.End()
+	return s.audiobookRepo.CatalogMeta(ctx)
 }
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mayank12gt/free-audiobooks-backend/internal/repos"
+)
+
+func testRepo() *repos.MemoryRepo {
+	return repos.NewMemoryRepo([]*repos.Audiobook{
+		{IDStr: "1", Title: "Moby Dick", Language: "English", TotalTimeSecs: 90000, Description: "The hunting of a white whale"},
+		{IDStr: "2", Title: "The Whale Road", Language: "English", TotalTimeSecs: 1800, Description: "Sea poems"},
+		{IDStr: "3", Title: "Emma", Language: "English", TotalTimeSecs: 30000, Description: "Matchmaking in a village"},
+	}, nil)
+}
+
+func TestAudiobookServiceList(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      Query
+		want       []string
+		highlights bool
+	}{
+		{"search sorts by relevance", Query{Search: "whale", Page: 1, PageSize: 10}, []string{"2", "1"}, true},
+		{"explicit sort wins over relevance", Query{Search: "whale", Sort: "-totaltimesecs", Page: 1, PageSize: 10}, []string{"1", "2"}, true},
+		{"sort", Query{Sort: "-totaltimesecs", Page: 1, PageSize: 10}, []string{"1", "3", "2"}, false},
+		{"length is given in minutes", Query{TotalTimeRange: TimeRange{30, 600}, Page: 1, PageSize: 10}, []string{"2", "3"}, false},
+		{"page", Query{Page: 2, PageSize: 2}, []string{"3"}, false},
+		{"cursor pages", Query{UseCursor: true, PageSize: 2}, []string{"1", "2"}, false},
+	}
+
+	service := NewService(testRepo(), time.Hour).AudiobooksService
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			audiobooks, _, err := service.List(context.Background(), tt.query)
+			if err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			got := []string{}
+			for _, a := range audiobooks {
+				got = append(got, a.IDStr)
+				if (a.Highlights != nil) != tt.highlights {
+					t.Errorf("book %s has highlights %+v", a.IDStr, a.Highlights)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAudiobookServiceFacets(t *testing.T) {
+	service := NewService(testRepo(), time.Hour).AudiobooksService
+
+	facets, err := service.Facets(context.Background(), Query{Page: 1, PageSize: 10})
+	if err != nil || facets != nil {
+		t.Errorf("facets nobody asked for = %+v, %v", facets, err)
+	}
+
+	// the length filter is given in minutes like the list, and the language facet keeps it
+	facets, err = service.Facets(context.Background(), Query{Facets: []string{"language"}, TotalTimeRange: TimeRange{30, 600}, Page: 1, PageSize: 10})
+	if err != nil {
+		t.Fatalf("Facets: %v", err)
+	}
+	if want := []repos.FacetCount{{Value: "English", Count: 2}}; !reflect.DeepEqual(facets.Languages, want) {
+		t.Errorf("languages = %+v, want %+v", facets.Languages, want)
+	}
+}
+
+func TestAudiobookServiceGetRecordsViews(t *testing.T) {
+	repo := testRepo()
+	services := NewService(repo, time.Hour)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if _, err := services.AudiobooksService.Get(ctx, "3"); err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+	}
+	if _, err := services.AudiobooksService.Get(ctx, "missing"); err == nil {
+		t.Fatal("expected not found")
+	}
+
+	// nothing is written until the batch goes out
+	if book, _ := repo.Get(ctx, "3"); book.Popularity != 0 {
+		t.Errorf("popularity before the flush = %d, want 0", book.Popularity)
+	}
+	if err := services.Close(ctx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if book, _ := repo.Get(ctx, "3"); book.Popularity != 3 {
+		t.Errorf("popularity after the flush = %d, want 3", book.Popularity)
+	}
+}
+
+// failingViews fails RecordViews a set number of times before handing the batches on
+type failingViews struct {
+	repos.AudiobooksRepository
+	failures int
+}
+
+func (f *failingViews) RecordViews(ctx context.Context, views map[string]int64) error {
+	if f.failures > 0 {
+		f.failures--
+		return errors.New("database down")
+	}
+	return f.AudiobooksRepository.RecordViews(ctx, views)
+}
+
+func TestViewRecorderKeepsFailedBatches(t *testing.T) {
+	repo := testRepo()
+	recorder := NewViewRecorder(&failingViews{AudiobooksRepository: repo, failures: 1}, time.Hour)
+	ctx := context.Background()
+
+	recorder.Record("1")
+	recorder.Record("1")
+	if err := recorder.flush(ctx); err == nil {
+		t.Fatal("expected the first flush to fail")
+	}
+	recorder.Record("1")
+	if err := recorder.Close(ctx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if book, _ := repo.Get(ctx, "1"); book.Popularity != 3 {
+		t.Errorf("popularity = %d, want 3", book.Popularity)
+	}
+}
+
+func TestViewRecorderNil(t *testing.T) {
+	var recorder *ViewRecorder
+	recorder.Record("1")
+	if err := recorder.Close(context.Background()); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
@@ -1,20 +1,30 @@
 package services
 
 import (
+	"context"
+	"time"
+
 	"github.com/mayank12gt/free-audiobooks-backend/internal/repos"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type Services struct {
 	AudiobooksService AudiobookService
+	views             *ViewRecorder
 }
 
-func NewService(db *mongo.Database) Services {
+// NewService builds the services on repo, detail views are written to it every viewFlush
+func NewService(repo repos.AudiobooksRepository, viewFlush time.Duration) Services {
+	views := NewViewRecorder(repo, viewFlush)
 	return Services{
 		AudiobooksService: AudiobookService{
-			audiobookRepo: repos.AudiobooksRepo{
-				DB: db,
-			},
+			audiobookRepo: repo,
+			views:         views,
 		},
+		views: views,
 	}
 }
+
+// Close writes the views still pending
+func (s Services) Close(ctx context.Context) error {
+	return s.views.Close(ctx)
+}
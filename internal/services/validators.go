@@ -1,14 +1,48 @@
 package services
 
 import (
+	"fmt"
+	"unicode/utf8"
+
 	Error "github.com/mayank12gt/free-audiobooks-backend/internal/errors"
+	"github.com/mayank12gt/free-audiobooks-backend/internal/repos"
 )
 
-func (q *Query) Validate() error {
+// Limits bound how large and how costly a single query may be
+type Limits struct {
+	MaxPageSize     int
+	MaxSearchLength int
+	MaxGenres       int
+}
+
+// CheckSearch rejects search strings longer than limits allow, field names the offending parameter
+func (l Limits) CheckSearch(field, search string) *Error.Err {
+	if problem := l.searchProblem(search); problem != "" {
+		return Error.NewError().Set(field, problem)
+	}
+	return nil
+}
+
+func (l Limits) searchProblem(search string) string {
+	if utf8.RuneCountInString(search) > l.MaxSearchLength {
+		return fmt.Sprintf("must be at most %d characters", l.MaxSearchLength)
+	}
+	return ""
+}
+
+func (q *Query) Validate(limits Limits) error {
 
 	err := Error.NewError()
-	if q.PageSize > 50 || q.PageSize < 1 {
-		err = err.Set("page_size", "max value is 50 and min value is 1")
+	if q.PageSize > limits.MaxPageSize || q.PageSize < 1 {
+		err = err.Set("page_size", fmt.Sprintf("max value is %d and min value is 1", limits.MaxPageSize))
+	}
+
+	if problem := limits.searchProblem(q.Search); problem != "" {
+		err.Set("search", problem)
+	}
+
+	if len(q.Genres) > limits.MaxGenres {
+		err.Set("genres", fmt.Sprintf("at most %d genre ids can be given", limits.MaxGenres))
 	}
 
 	if q.Page < 1 {
@@ -28,6 +62,46 @@ func (q *Query) Validate() error {
 		}
 	}
 
+	names, desc := repos.SplitSort(q.Sort)
+	seen := make(map[string]bool)
+	total := false
+	for i, name := range names {
+		field, ok := repos.SortFields[name]
+		if !ok {
+			err.Set("sort_by", "unknown sort field "+name+", allowed values are title, totaltimesecs, copyright_year, date_added, relevance and popularity")
+			continue
+		}
+		// date_added is unique per book, a key after it could never change the order
+		if total {
+			err.Set("sort_by", name+" can't come after date_added, which already orders every book")
+		}
+		total = total || field == "_id"
+		if seen[name] {
+			err.Set("sort_by", name+" is given more than once")
+		}
+		seen[name] = true
+		if name == repos.RelevanceSort {
+			if q.Search == "" {
+				err.Set("sort_by", "relevance can only be used with search")
+			}
+			if desc[i] {
+				err.Set("sort_by", "relevance always sorts best match first and can't be descending")
+			}
+		}
+	}
+
+	seenFacets := make(map[string]bool)
+	for _, facet := range q.Facets {
+		if facet != repos.GenresFacet && facet != repos.LanguageFacet && facet != repos.LengthFacet {
+			err.Set("facets", "unknown facet "+facet+", allowed values are genres, language and length")
+			continue
+		}
+		if seenFacets[facet] {
+			err.Set("facets", facet+" is given more than once")
+		}
+		seenFacets[facet] = true
+	}
+
 	if len(err.E) == 0 {
 		return nil
 	}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	Error "github.com/mayank12gt/free-audiobooks-backend/internal/errors"
+)
+
+var testLimits = Limits{MaxPageSize: 50, MaxSearchLength: 20, MaxGenres: 3}
+
+// invalidFields returns the fields err complains about, sorted
+func invalidFields(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var e *Error.Err
+	if !errors.As(err, &e) {
+		t.Fatalf("got %T, want *Error.Err", err)
+	}
+	fields := []string{}
+	for field := range e.E {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func TestQueryValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{"defaults", Query{Page: 1, PageSize: 20}, nil},
+		{"largest page size", Query{Page: 1, PageSize: 50}, nil},
+		{"page size too large", Query{Page: 1, PageSize: 51}, []string{"page_size"}},
+		{"page size zero", Query{Page: 1, PageSize: 0}, []string{"page_size"}},
+		{"page zero", Query{Page: 0, PageSize: 20}, []string{"page"}},
+		{"longest search", Query{Page: 1, PageSize: 20, Search: strings.Repeat("é", 20)}, nil},
+		{"search too long", Query{Page: 1, PageSize: 20, Search: strings.Repeat("a", 21)}, []string{"search"}},
+		{"most genres", Query{Page: 1, PageSize: 20, Genres: []string{"1", "2", "3"}}, nil},
+		{"too many genres", Query{Page: 1, PageSize: 20, Genres: []string{"1", "2", "3", "4"}}, []string{"genres"}},
+		{"length range", Query{Page: 1, PageSize: 20, TotalTimeRange: TimeRange{60, 120}}, nil},
+		{"only one length bound", Query{Page: 1, PageSize: 20, TotalTimeRange: TimeRange{TotalTimeMin: 60}}, []string{"length"}},
+		{"negative length", Query{Page: 1, PageSize: 20, TotalTimeRange: TimeRange{-60, 120}}, []string{"length"}},
+		{"reversed length", Query{Page: 1, PageSize: 20, TotalTimeRange: TimeRange{120, 60}}, []string{"length"}},
+		{"several sort keys", Query{Page: 1, PageSize: 20, Sort: "-totaltimesecs,title,date_added"}, nil},
+		{"unknown sort key", Query{Page: 1, PageSize: 20, Sort: "author"}, []string{"sort_by"}},
+		{"repeated sort key", Query{Page: 1, PageSize: 20, Sort: "title,-title"}, []string{"sort_by"}},
+		{"sort key after date_added", Query{Page: 1, PageSize: 20, Sort: "date_added,title"}, []string{"sort_by"}},
+		{"relevance with search", Query{Page: 1, PageSize: 20, Search: "emma", Sort: "relevance"}, nil},
+		{"relevance without search", Query{Page: 1, PageSize: 20, Sort: "relevance"}, []string{"sort_by"}},
+		{"descending relevance", Query{Page: 1, PageSize: 20, Search: "emma", Sort: "-relevance"}, []string{"sort_by"}},
+		{"facets", Query{Page: 1, PageSize: 20, Facets: []string{"genres", "language", "length"}}, nil},
+		{"unknown facet", Query{Page: 1, PageSize: 20, Facets: []string{"authors"}}, []string{"facets"}},
+		{"repeated facet", Query{Page: 1, PageSize: 20, Facets: []string{"length", "length"}}, []string{"facets"}},
+		{"every problem is reported", Query{Page: 0, PageSize: 0, Sort: "author"}, []string{"page", "page_size", "sort_by"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := invalidFields(t, tt.query.Validate(testLimits)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("invalid fields = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckSearch(t *testing.T) {
+	if err := testLimits.CheckSearch("q", strings.Repeat("a", 20)); err != nil {
+		t.Errorf("search at the limit rejected: %v", err)
+	}
+	err := testLimits.CheckSearch("q", strings.Repeat("a", 21))
+	if err == nil || err.E["q"] == "" {
+		t.Errorf("search over the limit not rejected on q: %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/mayank12gt/free-audiobooks-backend/internal/repos"
+)
+
+// ViewRecorder counts detail views in memory and writes them in batches, so reading a book never
+// waits on a write. A failed batch is kept for the next one, counts still pending when the
+// process dies are lost, which a popularity ranking can afford
+type ViewRecorder struct {
+	repo    repos.AudiobooksRepository
+	mu      sync.Mutex
+	pending map[string]int64
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// NewViewRecorder starts writing the recorded views to repo every interval until Close
+func NewViewRecorder(repo repos.AudiobooksRepository, interval time.Duration) *ViewRecorder {
+	v := &ViewRecorder{
+		repo:    repo,
+		pending: make(map[string]int64),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go v.run(interval)
+	return v
+}
+
+// Record counts one view of the book, a nil recorder counts nothing
+func (v *ViewRecorder) Record(id string) {
+	if v == nil {
+		return
+	}
+	v.mu.Lock()
+	v.pending[id]++
+	v.mu.Unlock()
+}
+
+// Close stops the background writes and writes what is still pending
+func (v *ViewRecorder) Close(ctx context.Context) error {
+	if v == nil {
+		return nil
+	}
+	close(v.stop)
+	<-v.stopped
+	return v.flush(ctx)
+}
+
+func (v *ViewRecorder) run(interval time.Duration) {
+	defer close(v.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := v.flush(context.Background()); err != nil {
+				slog.Warn("recording views failed, retrying with the next batch", "err", err)
+			}
+		case <-v.stop:
+			return
+		}
+	}
+}
+
+func (v *ViewRecorder) flush(ctx context.Context) error {
+	v.mu.Lock()
+	views := v.pending
+	v.pending = make(map[string]int64)
+	v.mu.Unlock()
+	if len(views) == 0 {
+		return nil
+	}
+
+	err := v.repo.RecordViews(ctx, views)
+	if err != nil {
+		v.mu.Lock()
+		for id, count := range views {
+			v.pending[id] += count
+		}
+		v.mu.Unlock()
+	}
+	return err
+}
@@ -0,0 +1,26 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestStartChild(t *testing.T) {
+	provider := sdktrace.NewTracerProvider()
+	defer provider.Shutdown(context.Background())
+
+	_, orphan := StartChild(context.Background(), "readiness")
+	if orphan.SpanContext().IsValid() {
+		t.Error("started a span without a parent")
+	}
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "request")
+	defer parent.End()
+	_, child := StartChild(ctx, "query")
+	defer child.End()
+	if child.SpanContext().TraceID() != parent.SpanContext().TraceID() {
+		t.Errorf("child trace %s, want %s", child.SpanContext().TraceID(), parent.SpanContext().TraceID())
+	}
+}